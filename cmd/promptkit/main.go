@@ -1,19 +1,46 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/auth"
+	"github.com/promptkit/promptkit/internal/cache"
+	"github.com/promptkit/promptkit/internal/config"
 	"github.com/promptkit/promptkit/internal/control"
+	"github.com/promptkit/promptkit/internal/crypt"
 	"github.com/promptkit/promptkit/internal/daemon"
+	"github.com/promptkit/promptkit/internal/diff"
 	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/mock"
+	"github.com/promptkit/promptkit/internal/otlp"
+	"github.com/promptkit/promptkit/internal/pricing"
+	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/internal/redact"
+	"github.com/promptkit/promptkit/internal/rerun"
+	"github.com/promptkit/promptkit/internal/retry"
+	"github.com/promptkit/promptkit/internal/sessionfile"
+	"github.com/promptkit/promptkit/internal/stats"
+	"github.com/promptkit/promptkit/internal/suite"
+	"github.com/promptkit/promptkit/internal/thread"
+	"github.com/promptkit/promptkit/internal/trace"
 	"github.com/promptkit/promptkit/internal/tui"
 	"github.com/promptkit/promptkit/internal/view"
+	"github.com/promptkit/promptkit/pkg/session"
 	cli "github.com/urfave/cli/v3"
 )
 
@@ -21,19 +48,75 @@ func main() {
 	cmd := &cli.Command{
 		Name:  "promptkit",
 		Usage: "manage promptkit",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "profile", Usage: "configuration profile (default $PROMPTKIT_PROFILE or the file's profile)"},
+		},
 		Commands: []*cli.Command{
 			{
 				Name:  "start",
 				Usage: "start daemon",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "addr", Value: ":8080", Usage: "listen address"},
-					&cli.StringFlag{Name: "backend", Value: "https://api.openai.com", Usage: "backend base URL"},
+					&cli.StringFlag{Name: "addr", Value: config.DefaultAddr, Usage: "listen address"},
+					&cli.StringFlag{Name: "backend", Value: config.DefaultBackend, Usage: "backend base URL"},
+					&cli.StringFlag{Name: "admin-addr", Value: config.DefaultAdminAddr, Usage: "admin listen address for /metrics (empty to disable)"},
+					&cli.BoolFlag{Name: "control", Usage: "also serve the UI's control API on --admin-addr and push sessions to it as they are recorded"},
+					&cli.BoolFlag{Name: "encrypt", Usage: "encrypt recorded sessions, creating a key if needed"},
+					&cli.BoolFlag{Name: "compress", Value: true, Usage: "gzip session logs after daily rotation"},
+					&cli.StringFlag{Name: "pricing", Usage: "YAML pricing table (USD per 1M tokens by model)"},
+					&cli.StringFlag{Name: "budgets", Usage: "YAML file of daily token/cost budgets per key, tag or globally"},
+					&cli.StringFlag{Name: "routes", Usage: "YAML routing table mapping models and paths to backends with fallbacks"},
+					&cli.StringFlag{Name: "limits", Usage: "YAML file of per-backend and per-model rate limits"},
+					&cli.IntFlag{Name: "retry-max-attempts", Value: 1, Usage: "attempts per request for 429s, 502-504s and connection errors (1 disables retries)"},
+					&cli.DurationFlag{Name: "retry-base-delay", Value: retry.DefaultBaseDelay, Usage: "initial retry backoff, doubled per attempt with jitter"},
+					&cli.DurationFlag{Name: "retry-max-delay", Value: retry.DefaultMaxDelay, Usage: "maximum retry backoff; longer Retry-After waits are not retried"},
+					&cli.BoolFlag{Name: "cache", Usage: "serve repeated temperature-0 requests from recorded sessions"},
+					&cli.DurationFlag{Name: "cache-ttl", Value: cache.DefaultTTL, Usage: "how long cached responses are served"},
+					&cli.BoolFlag{Name: "cache-allow-sampling", Usage: "also cache requests with a temperature other than 0"},
+					&cli.IntFlag{Name: "cache-max-entries", Value: cache.DefaultMaxEntries, Usage: "maximum number of cached responses; the least recently used are evicted"},
+					&cli.StringFlag{Name: "faults", Usage: "YAML file of failures to inject for resilience testing"},
+					&cli.StringFlag{Name: "otlp-endpoint", Sources: cli.EnvVars("OTEL_EXPORTER_OTLP_ENDPOINT"), Usage: "export sessions as OTLP/HTTP spans to this collector URL"},
+					&cli.StringFlag{Name: "otlp-headers", Sources: cli.EnvVars("OTEL_EXPORTER_OTLP_HEADERS"), Usage: "extra collector headers as k1=v1,k2=v2"},
+					&cli.BoolFlag{Name: "otlp-capture-content", Usage: "include prompts and completions as span events"},
+					&cli.BoolFlag{Name: "detach", Aliases: []string{"d"}, Usage: "run in the background, logging to daemon.log in the .promptkit directory"},
+					&cli.DurationFlag{Name: "shutdown-timeout", Value: daemon.DefaultShutdownTimeout, Usage: "how long to drain in-flight requests on shutdown"},
+					&cli.StringFlag{Name: "clients", Usage: "YAML file mapping local client API keys to client names and upstream keys"},
+					&cli.StringFlag{Name: "control-token", Usage: "bearer token required on --admin-addr (prefer control_token in config.yaml or $PROMPTKIT_CONTROL_TOKEN)"},
+					&cli.StringFlag{Name: "tls-cert", Usage: "PEM certificate to serve both listeners over HTTPS with"},
+					&cli.StringFlag{Name: "tls-key", Usage: "PEM private key for --tls-cert"},
 				},
 				Action: startDaemon,
 			},
 			{
-				Name:   "ui",
-				Usage:  "launch UI and control server",
+				Name:  "stop",
+				Usage: "stop the background daemon",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{Name: "timeout", Value: daemon.DefaultShutdownTimeout + 5*time.Second, Usage: "how long to wait for the daemon to exit"},
+				},
+				Action: stopCmd,
+			},
+			{
+				Name:  "status",
+				Usage: "show whether the daemon is running",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Value: "text", Usage: "output format (text|json)"},
+				},
+				Action: statusCmd,
+			},
+			{
+				Name:  "logs",
+				Usage: "print the background daemon's log",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "lines", Aliases: []string{"n"}, Value: 100, Usage: "number of lines to print (0 for all)"},
+					&cli.BoolFlag{Name: "follow", Aliases: []string{"f"}, Usage: "keep printing new output"},
+				},
+				Action: logsCmd,
+			},
+			{
+				Name:  "ui",
+				Usage: "launch UI and control server",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "addr", Value: config.DefaultUIAddr, Usage: "control server listen address"},
+				},
 				Action: uiCmd,
 			},
 			{
@@ -47,12 +130,116 @@ func main() {
 				},
 				Action: listCmd,
 			},
+			{
+				Name:        "stats",
+				Usage:       "aggregate latency, tokens, cost and errors",
+				Description: `Summarize recorded sessions: count, p50/p95/p99 latency, tokens, cost and error rate, optionally grouped by model, tag, origin, backend, day or hour. Sessions can be narrowed with the same --filter expressions as list.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "by", Usage: "group by model|tag|origin|backend|day|hour"},
+					&cli.StringFlag{Name: "filter", Usage: "query expression, as for list"},
+					&cli.StringFlag{Name: "output", Value: "table", Usage: "output format (table|json|csv)"},
+				},
+				Action: statsCmd,
+			},
 			{
 				Name:      "view",
 				Usage:     "view session details",
 				ArgsUsage: "<session-id>",
 				Action:    viewCmd,
 			},
+			{
+				Name:      "thread",
+				Usage:     "show a multi-turn conversation",
+				ArgsUsage: "<thread-or-session-id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Value: "text", Usage: "output format (text|json)"},
+				},
+				Action: threadCmd,
+			},
+			{
+				Name:      "trace",
+				Usage:     "show the call graph of an agent trace",
+				ArgsUsage: "<trace-id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "output", Value: "text", Usage: "output format (text|json)"},
+				},
+				Action: traceCmd,
+			},
+			{
+				Name:      "diff",
+				Usage:     "compare two sessions",
+				ArgsUsage: "<session-id> <session-id>",
+				Action:    diffCmd,
+			},
+			{
+				Name:      "rerun",
+				Usage:     "re-send a recorded session and diff the result",
+				ArgsUsage: "<session-id>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "model", Usage: "model to use instead of the recorded one"},
+					&cli.StringFlag{Name: "backend", Value: config.DefaultBackend, Usage: "backend base URL"},
+					&cli.StringFlag{Name: "api-key", Sources: cli.EnvVars("OPENAI_API_KEY"), Usage: "backend API key"},
+				},
+				Action: rerunCmd,
+			},
+			{
+				Name:        "test",
+				Usage:       "run a prompt regression suite",
+				ArgsUsage:   "<suite.yaml>",
+				Description: `Re-run recorded sessions selected by a suite (by session id, tag or filter) and check assertions on the new outputs: exact, contains, regex, json_schema, max_latency_ms, max_tokens and similarity. Exits non-zero if any case fails.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "backend", Usage: "backend or replay server base URL (overrides the suite)"},
+					&cli.StringFlag{Name: "model", Usage: "model to use instead of the recorded one (overrides the suite)"},
+					&cli.StringFlag{Name: "api-key", Sources: cli.EnvVars("OPENAI_API_KEY"), Usage: "backend API key"},
+					&cli.StringFlag{Name: "junit", Usage: "write a JUnit XML report to this file"},
+					&cli.BoolFlag{Name: "record", Usage: "record the new sessions"},
+				},
+				Action: testCmd,
+			},
+			{
+				Name:        "mock",
+				Usage:       "serve a scripted mock backend",
+				ArgsUsage:   "<mocks.yaml>",
+				Description: `Serve an OpenAI-compatible API from a YAML file of mocks. Each mock matches requests by model glob, last user message regex and offered tool name, and answers with canned content, tool calls or an API error. Streamed requests receive the content in chunks with configurable size and delays.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "addr", Value: ":8090", Usage: "listen address"},
+				},
+				Action: mockCmd,
+			},
+			{
+				Name:        "config",
+				Usage:       "show and change settings",
+				Description: `Settings are read from config.yaml in the .promptkit directory. Top-level settings apply to every profile and a profile overrides them; the profile is chosen with --profile, $PROMPTKIT_PROFILE or the file's "profile" key. Environment variables named PROMPTKIT_<KEY> (e.g. PROMPTKIT_BACKEND) override both, and command-line flags override everything. List settings take comma-separated values in the environment. Keys: ` + strings.Join(config.Keys(), ", ") + `.`,
+				Commands: []*cli.Command{
+					{
+						Name:      "get",
+						Usage:     "print the effective value of a setting",
+						ArgsUsage: "<key>",
+						Action:    configGetCmd,
+					},
+					{
+						Name:        "set",
+						Usage:       "store a setting in config.yaml (in --profile if given)",
+						ArgsUsage:   "<key> [value...]",
+						Description: `Store a setting at the top level of config.yaml, or in the profile named by --profile. List settings (record, redact) take one argument per element. Omitting the value unsets the key.`,
+						Action:      configSetCmd,
+					},
+					{
+						Name:  "list",
+						Usage: "print every effective setting and where it comes from",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "output", Value: "table", Usage: "output format (table|json)"},
+						},
+						Action: configListCmd,
+					},
+				},
+			},
+			{
+				Name:        "rekey",
+				Usage:       "rotate the session encryption key",
+				Description: `Re-encrypt all session logs with a new key. Plaintext logs are encrypted as well. The current key is unlocked with $PROMPTKIT_PASSPHRASE; set $PROMPTKIT_NEW_PASSPHRASE to derive the new key from a passphrase instead of generating a random one. Stop the daemon before rotating.`,
+				Action:      rekeyCmd,
+			},
 		},
 	}
 
@@ -61,10 +248,196 @@ func main() {
 	}
 }
 
+// settings returns the configuration of the selected profile.
+func settings(cmd *cli.Command) (config.Settings, error) {
+	f, err := config.LoadDefault()
+	if err != nil {
+		return config.Settings{}, fmt.Errorf("config: %w", err)
+	}
+	s, err := f.Resolve(cmd.String("profile"))
+	if err != nil {
+		return config.Settings{}, fmt.Errorf("config: %w", err)
+	}
+	return s, nil
+}
+
+// flagOr returns the string flag name if it was given, otherwise the
+// configured value.
+func flagOr(cmd *cli.Command, name, configured string) string {
+	if cmd.IsSet(name) {
+		return cmd.String(name)
+	}
+	return configured
+}
+
 func startDaemon(_ context.Context, cmd *cli.Command) error {
-	addr := cmd.String("addr")
-	backend := cmd.String("backend")
-	return daemon.Run(addr, backend)
+	s, err := settings(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.Bool("detach") {
+		return detach()
+	}
+	return daemon.Run(daemon.Config{
+		Addr:      flagOr(cmd, "addr", s.Addr),
+		Backend:   flagOr(cmd, "backend", s.Backend),
+		AdminAddr: flagOr(cmd, "admin-addr", s.AdminAddr),
+		Control:   cmd.Bool("control"),
+		Encrypt:   cmd.Bool("encrypt"),
+		Compress:  cmd.Bool("compress"),
+
+		Record:        s.Record,
+		Redact:        s.Redact,
+		RetentionDays: s.RetentionDays,
+
+		PricingFile: cmd.String("pricing"),
+		BudgetFile:  cmd.String("budgets"),
+		LimitsFile:  cmd.String("limits"),
+		RoutesFile:  cmd.String("routes"),
+		FaultsFile:  cmd.String("faults"),
+		Retry: retry.Policy{
+			MaxAttempts: int(cmd.Int("retry-max-attempts")),
+			BaseDelay:   cmd.Duration("retry-base-delay"),
+			MaxDelay:    cmd.Duration("retry-max-delay"),
+		},
+		Cache: cache.Config{
+			Enabled:       cmd.Bool("cache"),
+			TTL:           cmd.Duration("cache-ttl"),
+			AllowSampling: cmd.Bool("cache-allow-sampling"),
+			MaxEntries:    int(cmd.Int("cache-max-entries")),
+		},
+		ShutdownTimeout: cmd.Duration("shutdown-timeout"),
+		OTLP: otlp.Config{
+			Endpoint:       cmd.String("otlp-endpoint"),
+			Headers:        otlp.ParseHeaders(cmd.String("otlp-headers")),
+			CaptureContent: cmd.Bool("otlp-capture-content"),
+		},
+		ClientsFile:  cmd.String("clients"),
+		ControlToken: flagOr(cmd, "control-token", s.ControlToken),
+		TLSCert:      flagOr(cmd, "tls-cert", s.TLSCert),
+		TLSKey:       flagOr(cmd, "tls-key", s.TLSKey),
+	})
+}
+
+// apiClient returns an HTTP client for the daemon's admin endpoints and the
+// control API, sending the configured token and trusting the configured
+// certificate.
+func apiClient(cmd *cli.Command) (*http.Client, error) {
+	s, err := settings(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return auth.Client(s.ControlToken, s.TLSCert)
+}
+
+// detach re-runs the current command line without --detach in the
+// background.
+func detach() error {
+	if st, err := daemon.ReadState(); err == nil && st != nil && st.Alive() {
+		return cli.Exit(fmt.Sprintf("daemon already running (pid %d)", st.PID), 1)
+	}
+	logPath, err := appdir.DaemonLogPath()
+	if err != nil {
+		return err
+	}
+	var args []string
+	for _, a := range os.Args[1:] {
+		switch {
+		case a == "--detach", a == "-detach", a == "-d", strings.HasPrefix(a, "--detach="), strings.HasPrefix(a, "-detach="):
+			continue
+		}
+		args = append(args, a)
+	}
+	st, err := daemon.Detach(args, logPath, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ promptkit daemon started (pid %d) on %s\n", st.PID, st.Addr)
+	fmt.Printf("   logs: %s\n", logPath)
+	return nil
+}
+
+func stopCmd(ctx context.Context, cmd *cli.Command) error {
+	st, err := daemon.ReadState()
+	if err != nil {
+		return err
+	}
+	if st == nil || !st.Alive() {
+		if st != nil {
+			daemon.RemoveState()
+		}
+		fmt.Println("promptkit daemon is not running")
+		return nil
+	}
+	client, err := apiClient(cmd)
+	if err != nil {
+		return err
+	}
+	if err := daemon.Stop(ctx, client, st, cmd.Duration("timeout")); err != nil {
+		return err
+	}
+	fmt.Printf("✅ promptkit daemon stopped (pid %d)\n", st.PID)
+	return nil
+}
+
+func statusCmd(ctx context.Context, cmd *cli.Command) error {
+	st, err := daemon.ReadState()
+	if err != nil {
+		return err
+	}
+	if st == nil || !st.Alive() {
+		if st != nil {
+			daemon.RemoveState()
+		}
+		fmt.Println("promptkit daemon is not running")
+		return cli.Exit("", 3)
+	}
+
+	status := &daemon.Status{State: *st}
+	if st.AdminAddr != "" {
+		client, err := apiClient(cmd)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if status, err = daemon.FetchStatus(ctx, client, st); err != nil {
+			return fmt.Errorf("daemon (pid %d) is not responding on %s: %w", st.PID, st.AdminAddr, err)
+		}
+	}
+	if cmd.String("output") == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	fmt.Printf("promptkit daemon is running (pid %d)\n", status.PID)
+	fmt.Printf("  listening:  %s\n", status.Addr)
+	if status.AdminAddr != "" {
+		fmt.Printf("  admin:      %s\n", status.AdminAddr)
+	}
+	fmt.Printf("  started:    %s\n", status.StartedAt.Local().Format(time.DateTime))
+	if status.SessionLog != "" {
+		fmt.Printf("  uptime:     %s\n", (time.Duration(status.UptimeSeconds) * time.Second).String())
+		fmt.Printf("  in flight:  %d\n", status.InFlight)
+		fmt.Printf("  recorded:   %d sessions\n", status.Recorded)
+		fmt.Printf("  log:        %s\n", status.SessionLog)
+	}
+	return nil
+}
+
+func logsCmd(ctx context.Context, cmd *cli.Command) error {
+	logPath, err := appdir.DaemonLogPath()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	err = daemon.TailLog(ctx, os.Stdout, logPath, int(cmd.Int("lines")), cmd.Bool("follow"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cli.Exit("no daemon log yet; start the daemon with --detach", 1)
+	}
+	return err
 }
 
 func listCmd(_ context.Context, cmd *cli.Command) error {
@@ -105,6 +478,42 @@ func listCmd(_ context.Context, cmd *cli.Command) error {
 	return nil
 }
 
+func statsCmd(_ context.Context, cmd *cli.Command) error {
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	sessions, err := list.LoadSessions(dir)
+	if err != nil {
+		return err
+	}
+	pred, err := list.ParseFilter(cmd.String("filter"))
+	if err != nil {
+		return err
+	}
+	var selected []session.Session
+	for _, s := range sessions {
+		if pred(list.ToMap(s)) {
+			selected = append(selected, s)
+		}
+	}
+
+	groups, err := stats.Compute(selected, cmd.String("by"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	switch cmd.String("output") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	case "csv":
+		return stats.WriteCSV(os.Stdout, groups)
+	}
+	stats.PrintTable(os.Stdout, groups)
+	return nil
+}
+
 func viewCmd(_ context.Context, cmd *cli.Command) error {
 	if cmd.NArg() < 1 {
 		return cli.Exit("session id required", 1)
@@ -128,20 +537,472 @@ func viewCmd(_ context.Context, cmd *cli.Command) error {
 }
 
 func uiCmd(_ context.Context, cmd *cli.Command) error {
-	addr := "localhost:5140"
-	srv, err := control.NewServer(addr)
+	s, err := settings(cmd)
 	if err != nil {
 		return err
 	}
-
-	if err := srv.Start(); err != nil {
+	addr := flagOr(cmd, "addr", s.UIAddr)
+	client, err := auth.Client(s.ControlToken, s.TLSCert)
+	if err != nil {
 		return err
 	}
 
-	fmt.Println("🚀 Starting PromptKit control server on http://" + addr)
-	fmt.Println("✅ Control server ready")
+	// Attach to a daemon serving the control API unless an address was
+	// given explicitly.
+	var base string
+	st, _ := daemon.ReadState()
+	if !cmd.IsSet("addr") && st != nil && st.Alive() && st.ControlURL() != "" {
+		base = st.ControlURL()
+		fmt.Printf("✅ Using the control API of the promptkit daemon (pid %d) on %s\n", st.PID, base)
+	} else {
+		tlsConfig, err := auth.ServerTLS(s.TLSCert, s.TLSKey)
+		if err != nil {
+			return err
+		}
+		// Edits rewrite the session logs, which only the daemon can do
+		// safely, so this server is read-only.
+		srv, err := control.NewServer(addr, control.WithToken(s.ControlToken), control.WithTLS(tlsConfig))
+		if err != nil {
+			return err
+		}
+		if err := srv.Start(); err != nil {
+			return err
+		}
+		base = auth.BaseURL(addr, tlsConfig != nil)
+		fmt.Println("🚀 Starting PromptKit control server on " + base)
+		fmt.Println("✅ Control server ready")
+	}
 
-	ui := tui.New(addr)
+	ui := tui.New(base, client)
 	p := tea.NewProgram(ui)
 	return p.Start()
 }
+
+func threadCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("thread or session id required", 1)
+	}
+	id := cmd.Args().First()
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	sessions, err := list.LoadSessions(dir)
+	if err != nil {
+		return err
+	}
+	th := thread.Find(sessions, id)
+	if th == nil {
+		fmt.Fprintf(os.Stderr, "❌ thread '%s' not found\n", id)
+		return cli.Exit("", 1)
+	}
+
+	if cmd.String("output") == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(th)
+	}
+	fmt.Print(thread.Format(th))
+	return nil
+}
+
+func traceCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("trace id required", 1)
+	}
+	id := cmd.Args().First()
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	sessions, err := list.LoadSessions(dir)
+	if err != nil {
+		return err
+	}
+	tree := trace.Build(sessions, id)
+	if tree == nil {
+		fmt.Fprintf(os.Stderr, "❌ trace '%s' not found\n", id)
+		return cli.Exit("", 1)
+	}
+
+	if cmd.String("output") == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	}
+	fmt.Print(trace.Format(tree))
+	return nil
+}
+
+func diffCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 2 {
+		return cli.Exit("two session ids required", 1)
+	}
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	var sessions [2]*session.Session
+	for i, id := range cmd.Args().Slice()[:2] {
+		sess, err := view.FindSession(dir, id)
+		if err != nil {
+			return err
+		}
+		if sess == nil {
+			fmt.Fprintf(os.Stderr, "❌ session '%s' not found\n", id)
+			return cli.Exit("", 1)
+		}
+		sessions[i] = sess
+	}
+	fmt.Print(diff.Format(diff.Sessions(*sessions[0], *sessions[1])))
+	return nil
+}
+
+func rerunCmd(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("session id required", 1)
+	}
+	id := cmd.Args().First()
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	orig, err := view.FindSession(dir, id)
+	if err != nil {
+		return err
+	}
+	if orig == nil {
+		fmt.Fprintf(os.Stderr, "❌ session '%s' not found\n", id)
+		return cli.Exit("", 1)
+	}
+	s, err := settings(cmd)
+	if err != nil {
+		return err
+	}
+
+	sess, err := rerun.Run(ctx, *orig, rerun.Options{
+		Backend: flagOr(cmd, "backend", s.Backend),
+		Model:   cmd.String("model"),
+		APIKey:  cmd.String("api-key"),
+	})
+	if err != nil {
+		return err
+	}
+
+	rec, err := openSink(cmd)
+	if err != nil {
+		return err
+	}
+	defer rec.Close()
+	if err := rec.Record(&sess); err != nil {
+		return fmt.Errorf("record: %w", err)
+	}
+
+	fmt.Printf("✅ recorded session %s (status %d, %dms)\n\n", sess.ID, sess.Response.Status, sess.Metadata.LatencyMS)
+	fmt.Print(diff.Format(diff.Sessions(*orig, sess)))
+	return nil
+}
+
+func mockCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("mock file required", 1)
+	}
+	f, err := mock.Load(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	addr := cmd.String("addr")
+	log.Printf("serving %d mocks on %s", len(f.Mocks), addr)
+	return http.ListenAndServe(addr, mock.Handler(f))
+}
+
+func testCmd(ctx context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("suite file required", 1)
+	}
+	st, err := suite.Load(cmd.Args().First())
+	if err != nil {
+		return err
+	}
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	sessions, err := list.LoadSessions(dir)
+	if err != nil {
+		return err
+	}
+
+	results, err := st.Run(ctx, sessions, rerun.Options{
+		Backend: cmd.String("backend"),
+		Model:   cmd.String("model"),
+		APIKey:  cmd.String("api-key"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("record") {
+		rec, err := openSink(cmd)
+		if err != nil {
+			return err
+		}
+		defer rec.Close()
+		for _, r := range results {
+			if r.Output != nil {
+				if err := rec.Record(r.Output); err != nil {
+					return fmt.Errorf("record: %w", err)
+				}
+			}
+		}
+	}
+
+	if path := cmd.String("junit"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := suite.WriteJUnit(f, st.Name, results); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, r := range results {
+		name := r.Case
+		if r.Session != "" {
+			name += "/" + r.Session
+		}
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Printf("❌ %s: %v\n", name, r.Err)
+		case len(r.Failures) > 0:
+			failed++
+			fmt.Printf("❌ %s\n", name)
+			for _, f := range r.Failures {
+				fmt.Printf("   %s\n", f)
+			}
+		default:
+			fmt.Printf("✅ %s (%dms)\n", name, r.Duration.Milliseconds())
+		}
+	}
+	fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// sessionSink records sessions made by the CLI the way the daemon records
+// proxied ones: priced and redacted. While a daemon serves the control API
+// they are imported through it, so the session log keeps a single writer.
+type sessionSink struct {
+	redact  *redact.Redactor
+	pricing *pricing.Table
+	client  *http.Client
+	url     string
+	rec     *recorder.Recorder
+}
+
+// openSink opens a sessionSink for the configured settings.
+func openSink(cmd *cli.Command) (*sessionSink, error) {
+	s, err := settings(cmd)
+	if err != nil {
+		return nil, err
+	}
+	k := &sessionSink{}
+	if k.redact, err = redact.New(s.Redact); err != nil {
+		return nil, err
+	}
+	if k.pricing, err = pricing.LoadDefault(); err != nil {
+		return nil, fmt.Errorf("pricing: %w", err)
+	}
+	if st, _ := daemon.ReadState(); st != nil && st.Alive() && st.ControlURL() != "" {
+		if k.client, err = auth.Client(s.ControlToken, s.TLSCert); err != nil {
+			return nil, err
+		}
+		k.url = st.ControlURL() + "/sessions"
+		return k, nil
+	}
+	if k.rec, err = openRecorder(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Record prices, redacts and writes sess.
+func (k *sessionSink) Record(sess *session.Session) error {
+	k.pricing.Account(sess)
+	k.redact.Session(sess)
+	if hash, err := session.ComputeHash(*sess); err == nil {
+		sess.Metadata.SessionHash = hash
+	}
+	if k.rec != nil {
+		return k.rec.Record(sess)
+	}
+	body, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Post(k.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("daemon: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close closes the session log if the sink writes it directly.
+func (k *sessionSink) Close() error {
+	if k.rec == nil {
+		return nil
+	}
+	return k.rec.Close()
+}
+
+// openRecorder opens today's session log, encrypting if a key is configured.
+func openRecorder() (*recorder.Recorder, error) {
+	path, err := appdir.SessionLogPath()
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypt.LoadDefault()
+	if err != nil {
+		return nil, err
+	}
+	var opts []recorder.Option
+	if key != nil {
+		opts = append(opts, recorder.WithKey(key))
+	}
+	return recorder.New(path, opts...)
+}
+
+func configGetCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() != 1 {
+		return cli.Exit("setting key required", 1)
+	}
+	f, err := config.LoadDefault()
+	if err != nil {
+		return err
+	}
+	values, err := f.Effective(cmd.String("profile"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	for _, v := range values {
+		if v.Key == cmd.Args().First() {
+			fmt.Println(v.Value)
+			return nil
+		}
+	}
+	return cli.Exit(fmt.Sprintf("unknown setting %q (want one of %s)", cmd.Args().First(), strings.Join(config.Keys(), ", ")), 1)
+}
+
+func configSetCmd(_ context.Context, cmd *cli.Command) error {
+	if cmd.NArg() < 1 {
+		return cli.Exit("setting key required", 1)
+	}
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	f, err := config.LoadDefault()
+	if err != nil {
+		return err
+	}
+	args := cmd.Args().Slice()
+	if err := f.Set(cmd.String("profile"), args[0], args[1:]); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	return f.Save(path)
+}
+
+func configListCmd(_ context.Context, cmd *cli.Command) error {
+	f, err := config.LoadDefault()
+	if err != nil {
+		return err
+	}
+	profile := f.ActiveProfile(cmd.String("profile"))
+	values, err := f.Effective(profile)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if cmd.String("output") == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(values)
+	}
+
+	path, _ := config.Path()
+	fmt.Printf("config: %s\n", path)
+	if profile != "" {
+		fmt.Printf("profile: %s\n", profile)
+	}
+	if names := f.ProfileNames(); len(names) > 0 {
+		fmt.Printf("profiles: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Key\tValue\tSource")
+	for _, v := range values {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+	}
+	return tw.Flush()
+}
+
+func rekeyCmd(_ context.Context, cmd *cli.Command) error {
+	// A running daemon would keep appending to the replaced logs, sealed
+	// with the retired key.
+	if st, err := daemon.ReadState(); err == nil && st != nil && st.Alive() {
+		return cli.Exit(fmt.Sprintf("daemon running (pid %d); stop it before rotating the key", st.PID), 1)
+	}
+	dir, err := appdir.SessionsDir()
+	if err != nil {
+		return err
+	}
+	keyPath, err := crypt.KeyPath()
+	if err != nil {
+		return err
+	}
+	oldKey, err := crypt.LoadDefault()
+	if err != nil {
+		return err
+	}
+
+	// The new key is persisted before any file is rewritten so an interrupted
+	// rotation can be resumed with the same key.
+	pendingPath := keyPath + ".new"
+	newPass := os.Getenv("PROMPTKIT_NEW_PASSPHRASE")
+	newKey, err := crypt.Load(pendingPath, newPass)
+	if errors.Is(err, os.ErrNotExist) {
+		newKey, err = crypt.Generate(newPass)
+		if err == nil {
+			err = newKey.Save(pendingPath)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("new key: %w", err)
+	}
+
+	files, err := sessionfile.Files(dir)
+	if err != nil {
+		return err
+	}
+	dec := sessionfile.NewDecoder(oldKey, newKey)
+	for _, f := range files {
+		if err := sessionfile.Rekey(f, dec, newKey); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(pendingPath, keyPath); err != nil {
+		return err
+	}
+	fmt.Printf("🔑 re-encrypted %d session files with key %s\n", len(files), newKey.ID)
+	return nil
+}
@@ -72,6 +72,29 @@ func SessionLogPath() (string, error) {
 	if err := os.MkdirAll(sessionsDir, 0o755); err != nil {
 		return "", err
 	}
-	name := "chat-" + time.Now().Format("2006-01-02") + ".jsonl"
-	return filepath.Join(sessionsDir, name), nil
+	return filepath.Join(sessionsDir, SessionLogName(time.Now())), nil
+}
+
+// SessionLogName returns the session log file name for the day of t.
+func SessionLogName(t time.Time) string {
+	return "chat-" + t.Format("2006-01-02") + ".jsonl"
+}
+
+// DaemonPIDPath returns the path of the PID file written by a running
+// daemon.
+func DaemonPIDPath() (string, error) {
+	dir, err := PromptkitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.pid"), nil
+}
+
+// DaemonLogPath returns the path of the log written by a detached daemon.
+func DaemonLogPath() (string, error) {
+	dir, err := PromptkitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.log"), nil
 }
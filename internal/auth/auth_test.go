@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a certificate for localhost and its key to dir.
+func selfSigned(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestTokenAndTLS(t *testing.T) {
+	certFile, keyFile := selfSigned(t, t.TempDir())
+	cfg, err := ServerTLS(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(RequireToken("s3cret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(client *http.Client, url string) int {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	good, err := Client("s3cret", certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := get(good, srv.URL); status != http.StatusNoContent {
+		t.Errorf("with token = %d", status)
+	}
+	wrong, _ := Client("guess", certFile)
+	if status := get(wrong, srv.URL); status != http.StatusUnauthorized {
+		t.Errorf("with wrong token = %d", status)
+	}
+	anon, _ := Client("", certFile)
+	if status := get(anon, srv.URL+"/events?access_token=s3cret"); status != http.StatusNoContent {
+		t.Errorf("with query token = %d", status)
+	}
+
+	// The certificate is only trusted when given.
+	untrusted, _ := Client("s3cret", "")
+	if _, err := untrusted.Get(srv.URL); err == nil {
+		t.Error("self-signed certificate trusted without being configured")
+	}
+
+	if _, err := ServerTLS(certFile, ""); err == nil {
+		t.Error("certificate without key accepted")
+	}
+	if cfg, err := ServerTLS("", ""); cfg != nil || err != nil {
+		t.Errorf("ServerTLS() = %v, %v", cfg, err)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8081":          "http://localhost:8081",
+		"0.0.0.0:8081":   "http://localhost:8081",
+		"127.0.0.1:8081": "http://127.0.0.1:8081",
+	} {
+		if got := BaseURL(addr, false); got != want {
+			t.Errorf("BaseURL(%q) = %q, want %q", addr, got, want)
+		}
+	}
+	if got := BaseURL("localhost:8081", true); got != "https://localhost:8081" {
+		t.Errorf("BaseURL with TLS = %q", got)
+	}
+}
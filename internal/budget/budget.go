@@ -0,0 +1,333 @@
+package budget
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// FileName is the default budget file under the promptkit directory.
+const FileName = "budgets.yaml"
+
+// Scope selects what a budget is counted against.
+type Scope string
+
+const (
+	ScopeGlobal Scope = "global" // all requests
+	ScopeKey    Scope = "key"    // requests made with an API key
+	ScopeTag    Scope = "tag"    // requests carrying a tag
+)
+
+// Limit is a daily token and/or cost budget. With an empty Match, key and
+// tag budgets apply to every key or tag separately.
+type Limit struct {
+	Scope      Scope   `yaml:"scope"`
+	Match      string  `yaml:"match"`
+	MaxTokens  int     `yaml:"max_tokens"`
+	MaxCostUSD float64 `yaml:"max_cost_usd"`
+
+	matchHash string
+}
+
+// Config is the budget file format.
+type Config struct {
+	Budgets []Limit `yaml:"budgets"`
+}
+
+// Subject identifies who a request is counted against.
+type Subject struct {
+	KeyHash string
+	Tags    []string
+}
+
+// SubjectOf returns the subject of a recorded session.
+func SubjectOf(s session.Session) Subject {
+	return Subject{KeyHash: s.Metadata.KeyHash, Tags: s.Metadata.Tags}
+}
+
+// Fingerprint returns a short, non-reversible identifier of an API key that
+// is safe to store with sessions.
+func Fingerprint(key string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ExceededError reports a request rejected because a budget is spent.
+type ExceededError struct {
+	Limit   Limit
+	Subject string
+}
+
+func (e *ExceededError) Error() string {
+	what := "global"
+	if e.Limit.Scope != ScopeGlobal {
+		what = fmt.Sprintf("%s %q", e.Limit.Scope, e.Subject)
+	}
+	var amount string
+	switch {
+	case e.Limit.MaxTokens > 0 && e.Limit.MaxCostUSD > 0:
+		amount = fmt.Sprintf("%d tokens or $%.2f", e.Limit.MaxTokens, e.Limit.MaxCostUSD)
+	case e.Limit.MaxTokens > 0:
+		amount = fmt.Sprintf("%d tokens", e.Limit.MaxTokens)
+	default:
+		amount = fmt.Sprintf("$%.2f", e.Limit.MaxCostUSD)
+	}
+	return fmt.Sprintf("daily budget of %s for %s exceeded", amount, what)
+}
+
+type spend struct {
+	tokens int
+	cost   float64
+}
+
+// Enforcer tracks spending against budgets for the current day. The daemon
+// runs with a nil *Enforcer when no budgets are configured; it admits every
+// request and counts nothing.
+type Enforcer struct {
+	limits []Limit
+	now    func() time.Time
+
+	mu       sync.Mutex
+	day      string
+	spent    map[string]*spend
+	reserved map[string]*spend
+}
+
+// New returns an enforcer for the given limits.
+func New(limits []Limit) (*Enforcer, error) {
+	for i := range limits {
+		l := &limits[i]
+		switch l.Scope {
+		case ScopeGlobal, ScopeTag:
+		case ScopeKey:
+			l.matchHash = Fingerprint(l.Match)
+		default:
+			return nil, fmt.Errorf("budget %d: unknown scope %q", i+1, l.Scope)
+		}
+		if l.MaxTokens <= 0 && l.MaxCostUSD <= 0 {
+			return nil, fmt.Errorf("budget %d: max_tokens or max_cost_usd required", i+1)
+		}
+	}
+	return &Enforcer{limits: limits, now: time.Now, spent: map[string]*spend{}, reserved: map[string]*spend{}}, nil
+}
+
+// Load reads budgets from a YAML file.
+func Load(file string) (*Enforcer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return New(cfg.Budgets)
+}
+
+// LoadDefault loads FileName from the promptkit directory. It returns nil if
+// the file does not exist.
+func LoadDefault() (*Enforcer, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return nil, err
+	}
+	e, err := Load(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// subjects returns the counter keys of every limit that applies to sub,
+// along with the subject value each was matched on.
+func (e *Enforcer) subjects(sub Subject) (keys []string, limits []Limit, values []string) {
+	for i, l := range e.limits {
+		var vals []string
+		switch l.Scope {
+		case ScopeGlobal:
+			vals = []string{""}
+		case ScopeKey:
+			if sub.KeyHash != "" && (l.Match == "" || l.matchHash == sub.KeyHash) {
+				vals = []string{sub.KeyHash}
+			}
+		case ScopeTag:
+			for _, t := range sub.Tags {
+				if l.Match == "" || l.Match == t {
+					vals = append(vals, t)
+				}
+			}
+		}
+		for _, v := range vals {
+			keys = append(keys, fmt.Sprintf("%d/%s", i, v))
+			limits = append(limits, l)
+			values = append(values, v)
+		}
+	}
+	return keys, limits, values
+}
+
+// roll resets the counters when the day changes. e.mu must be held.
+func (e *Enforcer) roll() {
+	day := e.now().Format("2006-01-02")
+	if day != e.day {
+		e.day = day
+		e.spent = map[string]*spend{}
+		e.reserved = map[string]*spend{}
+	}
+}
+
+// Check returns an *ExceededError if any budget that applies to sub is spent.
+// Spend reserved by requests still in flight counts as spent.
+func (e *Enforcer) Check(sub Subject) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roll()
+	return e.check(sub)
+}
+
+// check is Check with e.mu held.
+func (e *Enforcer) check(sub Subject) error {
+	keys, limits, values := e.subjects(sub)
+	for i, k := range keys {
+		var tokens int
+		var cost float64
+		for _, sp := range []*spend{e.spent[k], e.reserved[k]} {
+			if sp != nil {
+				tokens += sp.tokens
+				cost += sp.cost
+			}
+		}
+		l := limits[i]
+		if (l.MaxTokens > 0 && tokens >= l.MaxTokens) || (l.MaxCostUSD > 0 && cost >= l.MaxCostUSD) {
+			subject := values[i]
+			if l.Scope == ScopeKey && l.Match != "" {
+				subject = l.matchHash
+			}
+			return &ExceededError{Limit: l, Subject: subject}
+		}
+	}
+	return nil
+}
+
+// Reservation holds the estimated spend of an admitted request against its
+// budgets, so concurrent requests cannot all pass Check before any of them is
+// counted. Done must be called once the request's actual spend has been
+// added.
+type Reservation struct {
+	e      *Enforcer
+	day    string
+	keys   []string
+	tokens int
+	cost   float64
+}
+
+// Reserve checks the budgets that apply to sub like Check and, if none is
+// spent, reserves the given estimated tokens and cost against them.
+func (e *Enforcer) Reserve(sub Subject, tokens int, cost float64) (*Reservation, error) {
+	if e == nil {
+		return nil, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roll()
+	if err := e.check(sub); err != nil {
+		return nil, err
+	}
+	keys, _, _ := e.subjects(sub)
+	for _, k := range keys {
+		sp := e.reserved[k]
+		if sp == nil {
+			sp = &spend{}
+			e.reserved[k] = sp
+		}
+		sp.tokens += tokens
+		sp.cost += cost
+	}
+	return &Reservation{e: e, day: e.day, keys: keys, tokens: tokens, cost: cost}, nil
+}
+
+// Done releases the reserved spend.
+func (r *Reservation) Done() {
+	if r == nil {
+		return
+	}
+	e := r.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r.day != e.day {
+		// The counters were reset at midnight along with the reservation.
+		return
+	}
+	for _, k := range r.keys {
+		if sp := e.reserved[k]; sp != nil {
+			sp.tokens -= r.tokens
+			sp.cost -= r.cost
+		}
+	}
+}
+
+// Add counts a completed request against every budget that applies to sub.
+func (e *Enforcer) Add(sub Subject, tokens int, cost float64) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.roll()
+	keys, _, _ := e.subjects(sub)
+	for _, k := range keys {
+		sp := e.spent[k]
+		if sp == nil {
+			sp = &spend{}
+			e.spent[k] = sp
+		}
+		sp.tokens += tokens
+		sp.cost += cost
+	}
+}
+
+// AddSession counts a recorded session. Rejected sessions and cache hits
+// cost nothing.
+func (e *Enforcer) AddSession(s session.Session) {
+	if e == nil || s.Metadata.Status == session.StatusRejected {
+		return
+	}
+	if c := s.Metadata.Cache; c != nil && c.Status == session.CacheHit {
+		return
+	}
+	u := s.Usage()
+	tokens := u.PromptTokens + u.CompletionTokens
+	if mu := s.Metadata.Usage; mu != nil {
+		tokens = mu.PromptTokens + mu.CompletionTokens
+	}
+	e.Add(SubjectOf(s), tokens, s.Metadata.CostUSD)
+}
+
+// Seed counts the sessions recorded today so budgets survive a restart.
+func (e *Enforcer) Seed(sessions []session.Session) {
+	if e == nil {
+		return
+	}
+	today := e.now().Format("2006-01-02")
+	for _, s := range sessions {
+		if s.Metadata.Timestamp.In(time.Local).Format("2006-01-02") == today {
+			e.AddSession(s)
+		}
+	}
+}
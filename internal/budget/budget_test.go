@@ -0,0 +1,132 @@
+package budget
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestEnforcer(t *testing.T) {
+	e, err := New([]Limit{
+		{Scope: ScopeGlobal, MaxCostUSD: 1},
+		{Scope: ScopeKey, MaxTokens: 100},
+		{Scope: ScopeKey, Match: "sk-team", MaxTokens: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	e.now = func() time.Time { return day }
+
+	alice := Subject{KeyHash: Fingerprint("sk-alice")}
+	team := Subject{KeyHash: Fingerprint("sk-team")}
+
+	e.Add(alice, 99, 0.1)
+	if err := e.Check(alice); err != nil {
+		t.Fatalf("under budget: %v", err)
+	}
+	e.Add(alice, 1, 0.1)
+	var exceeded *ExceededError
+	if err := e.Check(alice); !errors.As(err, &exceeded) || exceeded.Limit.MaxTokens != 100 {
+		t.Fatalf("Check(alice) = %v, want per-key budget exceeded", err)
+	}
+	if err := e.Check(team); err != nil {
+		t.Fatalf("keys share a per-key budget: %v", err)
+	}
+	e.Add(team, 10, 0)
+	if err := e.Check(team); !errors.As(err, &exceeded) || exceeded.Limit.MaxTokens != 10 {
+		t.Fatalf("Check(team) = %v, want matched budget exceeded", err)
+	}
+
+	e.Add(Subject{}, 0, 0.8)
+	if err := e.Check(Subject{}); !errors.As(err, &exceeded) || exceeded.Limit.Scope != ScopeGlobal {
+		t.Fatalf("Check() = %v, want global budget exceeded", err)
+	}
+
+	day = day.Add(24 * time.Hour)
+	if err := e.Check(alice); err != nil {
+		t.Fatalf("budget not reset the next day: %v", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	e, _ := New([]Limit{{Scope: ScopeGlobal, MaxTokens: 100, MaxCostUSD: 1}})
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	e.now = func() time.Time { return day }
+
+	first, err := e.Reserve(Subject{}, 60, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := e.Reserve(Subject{}, 60, 0)
+	if err != nil {
+		t.Fatalf("budget not yet spent: %v", err)
+	}
+	var exceeded *ExceededError
+	if _, err := e.Reserve(Subject{}, 1, 0); !errors.As(err, &exceeded) {
+		t.Fatalf("Reserve = %v, want in-flight spend to count", err)
+	}
+	first.Done()
+	second.Done()
+	if err := e.Check(Subject{}); err != nil {
+		t.Fatalf("reservations not released: %v", err)
+	}
+
+	held, err := e.Reserve(Subject{}, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Check(Subject{}); !errors.As(err, &exceeded) {
+		t.Fatalf("Check = %v, want reserved cost to count", err)
+	}
+
+	// A request finishing after midnight must not eat into the new day.
+	day = day.Add(24 * time.Hour)
+	if _, err := e.Reserve(Subject{}, 0, 0.5); err != nil {
+		t.Fatalf("budget not reset the next day: %v", err)
+	}
+	held.Done()
+	if _, err := e.Reserve(Subject{}, 0, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Check(Subject{}); !errors.As(err, &exceeded) {
+		t.Fatalf("Check = %v, want yesterday's release ignored", err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	e, _ := New([]Limit{{Scope: ScopeTag, MaxTokens: 10}})
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.Local)
+	e.now = func() time.Time { return now }
+
+	mk := func(ts time.Time, status session.Status) session.Session {
+		return session.Session{Metadata: session.Metadata{
+			Timestamp: ts,
+			Tags:      []string{"ci"},
+			Status:    status,
+			Usage:     &session.TokenUsage{PromptTokens: 5, CompletionTokens: 5},
+		}}
+	}
+	e.Seed([]session.Session{
+		mk(now.Add(-24*time.Hour), ""),
+		mk(now, session.StatusRejected),
+	})
+	if err := e.Check(Subject{Tags: []string{"ci"}}); err != nil {
+		t.Fatalf("old and rejected sessions counted: %v", err)
+	}
+	e.Seed([]session.Session{mk(now.Add(-time.Hour), "")})
+	if err := e.Check(Subject{Tags: []string{"ci"}}); err == nil {
+		t.Fatal("today's session not counted")
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New([]Limit{{Scope: "team", MaxTokens: 1}}); err == nil {
+		t.Error("unknown scope accepted")
+	}
+	if _, err := New([]Limit{{Scope: ScopeGlobal}}); err == nil {
+		t.Error("budget without a limit accepted")
+	}
+}
@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Header is sent by clients with the value "allow" to cache a request that
+// samples (temperature other than 0). Responses carry the cache status in it.
+const Header = "X-Promptkit-Cache"
+
+// DefaultTTL is how long responses are served from the cache by default.
+const DefaultTTL = 24 * time.Hour
+
+// DefaultMaxEntries is how many responses the cache holds by default.
+const DefaultMaxEntries = 10000
+
+// volatile request fields that do not change the response.
+var volatile = []string{"user", "metadata"}
+
+// Config configures the cache.
+type Config struct {
+	Enabled bool
+	TTL     time.Duration
+	// AllowSampling caches requests regardless of their temperature.
+	AllowSampling bool
+	// MaxEntries bounds the cache; the least recently used entries are
+	// evicted beyond it.
+	MaxEntries int
+}
+
+// Cache serves repeated requests from recorded sessions. It is nil unless
+// caching is enabled; a nil *Cache yields no keys and stores nothing.
+type Cache struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *entry, most recently used first
+}
+
+type entry struct {
+	key string
+	s   session.Session
+}
+
+// New returns an empty cache.
+func New(cfg Config) *Cache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultMaxEntries
+	}
+	return &Cache{cfg: cfg, now: time.Now, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Key returns the fingerprint of a request, or "" if it must not be cached:
+// the client sent Cache-Control: no-store, the body is not a JSON object, or
+// the request samples and sampling was not allowed. Requests made with
+// different API keys never share entries.
+func (c *Cache) Key(path string, h http.Header, body []byte, keyHash string) string {
+	if c == nil || hasDirective(h, "no-store") {
+		return ""
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	temp, ok := payload["temperature"].(float64)
+	deterministic := ok && temp == 0
+	if !deterministic && !c.cfg.AllowSampling && !strings.EqualFold(h.Get(Header), "allow") {
+		return ""
+	}
+	for _, k := range volatile {
+		delete(payload, k)
+	}
+	canonical, _ := json.Marshal(payload) // map keys are sorted
+	sum := sha256.New()
+	sum.Write([]byte(keyHash + "\n" + path + "\n"))
+	sum.Write(canonical)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// Bypass reports whether the client asked for a fresh response with
+// Cache-Control: no-cache. The fresh response is still stored.
+func Bypass(h http.Header) bool {
+	return hasDirective(h, "no-cache")
+}
+
+func hasDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Get returns the session stored under key if it has not expired.
+func (c *Cache) Get(key string) (session.Session, bool) {
+	if c == nil {
+		return session.Session{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return session.Session{}, false
+	}
+	e := el.Value.(*entry)
+	if c.expired(e.s) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return session.Session{}, false
+	}
+	c.lru.MoveToFront(el)
+	return e.s, true
+}
+
+func (c *Cache) expired(s session.Session) bool {
+	return c.now().Sub(s.Metadata.Timestamp) > c.cfg.TTL
+}
+
+// Put stores a successful session fetched from the backend under its cache
+// key, evicting the least recently used entry if the cache is full. Expired
+// sessions are not stored.
+func (c *Cache) Put(s session.Session) {
+	info := s.Metadata.Cache
+	if c == nil || info == nil || info.Key == "" || info.Status == session.CacheHit || s.Response.Status != http.StatusOK {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired(s) {
+		return
+	}
+	if el, ok := c.entries[info.Key]; ok {
+		el.Value.(*entry).s = s
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[info.Key] = c.lru.PushFront(&entry{key: info.Key, s: s})
+	for c.lru.Len() > c.cfg.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// Seed stores recorded sessions, oldest first, so the cache survives a
+// restart.
+func (c *Cache) Seed(sessions []session.Session) {
+	if c == nil {
+		return
+	}
+	for _, s := range sessions {
+		c.Put(s)
+	}
+}
+
+// Body returns the recorded response body of s and its content type.
+func Body(s session.Session) (contentType string, body []byte) {
+	switch b := s.Response.Body.(type) {
+	case string:
+		if s.Stream {
+			return "text/event-stream", []byte(b)
+		}
+		return "text/plain; charset=utf-8", []byte(b)
+	case nil:
+		body, _ = json.Marshal(s.Response)
+	default:
+		body, _ = json.Marshal(b)
+	}
+	return "application/json", body
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestKey(t *testing.T) {
+	c := New(Config{})
+	path := "/v1/chat/completions"
+	a := c.Key(path, http.Header{}, []byte(`{"model":"m","temperature":0,"messages":[],"user":"alice"}`), "k")
+	b := c.Key(path, http.Header{}, []byte(`{"user":"bob","messages":[],"temperature":0,"model":"m"}`), "k")
+	if a == "" || a != b {
+		t.Fatalf("equivalent requests got keys %q and %q", a, b)
+	}
+	if c.Key(path, http.Header{}, []byte(`{"model":"m","temperature":0,"messages":[]}`), "other") == a {
+		t.Error("keys shared across API keys")
+	}
+	if k := c.Key(path, http.Header{}, []byte(`{"model":"m"}`), "k"); k != "" {
+		t.Error("sampling request cached")
+	}
+	if k := c.Key(path, http.Header{Header: {"allow"}}, []byte(`{"model":"m"}`), "k"); k == "" {
+		t.Error("sampling request not cached when allowed by header")
+	}
+	if k := c.Key(path, http.Header{"Cache-Control": {"no-store"}}, []byte(`{"model":"m","temperature":0}`), "k"); k != "" {
+		t.Error("no-store request cached")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	c := New(Config{TTL: time.Hour})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	s := session.Session{
+		ID:       "1",
+		Response: session.OpenAIResponse{Status: http.StatusOK},
+		Metadata: session.Metadata{Timestamp: now.Add(-30 * time.Minute), Cache: &session.CacheInfo{Status: session.CacheMiss, Key: "k"}},
+	}
+	c.Put(s)
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("fresh entry missing")
+	}
+	now = now.Add(time.Hour)
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("expired entry served")
+	}
+
+	s.Response.Status = http.StatusInternalServerError
+	c.Put(s)
+	if _, ok := c.Get("k"); ok {
+		t.Fatal("failed response cached")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := New(Config{TTL: time.Hour, MaxEntries: 2})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	mk := func(key string, age time.Duration) session.Session {
+		return session.Session{
+			Response: session.OpenAIResponse{Status: http.StatusOK},
+			Metadata: session.Metadata{Timestamp: now.Add(-age), Cache: &session.CacheInfo{Status: session.CacheMiss, Key: key}},
+		}
+	}
+	c.Seed([]session.Session{mk("old", 2*time.Hour), mk("a", time.Minute), mk("b", time.Minute)})
+	if len(c.entries) != 2 {
+		t.Fatalf("%d entries, want expired session skipped", len(c.entries))
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("entry a missing")
+	}
+	c.Put(mk("c", 0))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("entry %s missing", k)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+)
+
+// FileName is the default client key map under the promptkit directory.
+const FileName = "clients.yaml"
+
+// ErrUnknownKey is returned for requests made with a key that is not mapped
+// to a client.
+var ErrUnknownKey = errors.New("unknown API key")
+
+// Client is a local client of the proxy, identified by the API key it sends.
+type Client struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+	// UpstreamKey replaces Key when the request is forwarded, so local
+	// clients never hold the backend's key. It may reference environment
+	// variables. Empty forwards Key unchanged.
+	UpstreamKey string `yaml:"upstream_key"`
+}
+
+// Config is the client file format.
+type Config struct {
+	Clients []Client `yaml:"clients"`
+	// AllowUnknown forwards requests made with keys that are not listed
+	// instead of rejecting them.
+	AllowUnknown bool `yaml:"allow_unknown"`
+}
+
+// Map identifies the clients of the proxy by API key.
+type Map struct {
+	byKey        map[string]*Client
+	allowUnknown bool
+}
+
+// New returns a map of the configured clients.
+func New(cfg Config) (*Map, error) {
+	m := &Map{byKey: make(map[string]*Client, len(cfg.Clients)), allowUnknown: cfg.AllowUnknown}
+	for i := range cfg.Clients {
+		c := &cfg.Clients[i]
+		if c.Name == "" || c.Key == "" {
+			return nil, fmt.Errorf("client %d: name and key required", i+1)
+		}
+		if _, dup := m.byKey[c.Key]; dup {
+			return nil, fmt.Errorf("client %s: key already used by another client", c.Name)
+		}
+		c.UpstreamKey = os.ExpandEnv(c.UpstreamKey)
+		m.byKey[c.Key] = c
+	}
+	return m, nil
+}
+
+// Load reads a client map from a YAML file.
+func Load(file string) (*Map, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return New(cfg)
+}
+
+// LoadDefault loads FileName from the promptkit directory. It returns nil if
+// the file does not exist.
+func LoadDefault() (*Map, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return nil, err
+	}
+	m, err := Load(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// Identify returns the client that sent key. Unknown keys, including no key
+// at all, yield a nil client if they are allowed and ErrUnknownKey if not.
+// A nil *Map, used when no clients are configured, allows every key.
+func (m *Map) Identify(key string) (*Client, error) {
+	if m == nil {
+		return nil, nil
+	}
+	if c, ok := m.byKey[key]; ok {
+		return c, nil
+	}
+	if m.allowUnknown {
+		return nil, nil
+	}
+	return nil, ErrUnknownKey
+}
@@ -0,0 +1,50 @@
+package clients
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentify(t *testing.T) {
+	t.Setenv("UPSTREAM", "sk-real")
+	path := filepath.Join(t.TempDir(), FileName)
+	os.WriteFile(path, []byte(`
+clients:
+  - name: notebook
+    key: pk-notebook
+    upstream_key: ${UPSTREAM}
+  - name: agent
+    key: pk-agent
+`), 0o600)
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := m.Identify("pk-notebook")
+	if err != nil || c.Name != "notebook" || c.UpstreamKey != "sk-real" {
+		t.Errorf("Identify(pk-notebook) = %+v, %v", c, err)
+	}
+	if c, _ := m.Identify("pk-agent"); c.UpstreamKey != "" {
+		t.Errorf("agent upstream key = %q", c.UpstreamKey)
+	}
+	for _, key := range []string{"sk-other", ""} {
+		if _, err := m.Identify(key); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Identify(%q) error = %v", key, err)
+		}
+	}
+
+	m.allowUnknown = true
+	if c, err := m.Identify("sk-other"); c != nil || err != nil {
+		t.Errorf("Identify with unknown keys allowed = %+v, %v", c, err)
+	}
+	if c, err := (*Map)(nil).Identify("x"); c != nil || err != nil {
+		t.Errorf("nil map = %+v, %v", c, err)
+	}
+
+	if _, err := New(Config{Clients: []Client{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}}); err == nil {
+		t.Error("duplicate keys accepted")
+	}
+}
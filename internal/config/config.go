@@ -0,0 +1,518 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+)
+
+// FileName is the name of the configuration file in the promptkit directory.
+const FileName = "config.yaml"
+
+// EnvPrefix prefixes the environment variables overriding settings, e.g.
+// PROMPTKIT_BACKEND. PROMPTKIT_PROFILE selects the profile.
+const EnvPrefix = "PROMPTKIT_"
+
+// Built-in defaults.
+const (
+	DefaultAddr      = ":8080"
+	DefaultAdminAddr = "localhost:8081"
+	DefaultBackend   = "https://api.openai.com"
+	DefaultUIAddr    = "localhost:5140"
+)
+
+// DefaultRecord lists the endpoints the daemon records by default.
+var DefaultRecord = []string{"/v1/completions", "/v1/chat/completions"}
+
+// Settings are the values a configuration file or profile can set. Keys
+// left out of a layer fall through to the next one; a key set to an empty
+// value overrides it.
+type Settings struct {
+	// Addr is the proxy listen address.
+	Addr string `yaml:"addr,omitempty"`
+	// AdminAddr is the daemon admin listen address.
+	AdminAddr string `yaml:"admin_addr,omitempty"`
+	// Backend is the default upstream base URL.
+	Backend string `yaml:"backend,omitempty"`
+	// UIAddr is the control server listen address used by ui.
+	UIAddr string `yaml:"ui_addr,omitempty"`
+	// Record lists the paths of POST endpoints whose calls are recorded.
+	Record []string `yaml:"record,omitempty"`
+	// Redact lists regular expressions whose matches are masked in recorded
+	// sessions.
+	Redact []string `yaml:"redact,omitempty"`
+	// RetentionDays deletes session logs older than this many days. Zero
+	// keeps them forever.
+	RetentionDays int `yaml:"retention_days,omitempty"`
+	// ControlToken is the bearer token the daemon's admin endpoints and the
+	// UI's control server require, and the one the CLI sends to them.
+	ControlToken string `yaml:"control_token,omitempty"`
+	// TLSCert and TLSKey are PEM files the daemon and the UI's control
+	// server serve HTTPS with. The CLI trusts TLSCert when connecting.
+	TLSCert string `yaml:"tls_cert,omitempty"`
+	TLSKey  string `yaml:"tls_key,omitempty"`
+
+	// set holds the keys given explicitly, even if to a zero value.
+	set map[string]bool
+}
+
+// has reports whether the setting fd is set in s.
+func (s *Settings) has(fd field) bool {
+	return s.set[fd.key] || !isZero(fd.ptr(s))
+}
+
+// assign parses values into the setting fd. No values unset it.
+func (s *Settings) assign(fd field, values []string) error {
+	if err := parse(fd.ptr(s), values); err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		delete(s.set, fd.key)
+		return nil
+	}
+	if s.set == nil {
+		s.set = map[string]bool{}
+	}
+	s.set[fd.key] = true
+	return nil
+}
+
+// UnmarshalYAML decodes settings and remembers which keys were present.
+func (s *Settings) UnmarshalYAML(n *yaml.Node) error {
+	type plain Settings
+	if err := n.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.set = presentKeys(n)
+	return nil
+}
+
+// MarshalYAML encodes the settings that are set, including those set to a
+// zero value.
+func (s Settings) MarshalYAML() (any, error) {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for _, fd := range fields {
+		if !s.has(fd) {
+			continue
+		}
+		var v yaml.Node
+		if err := v.Encode(fd.ptr(&s)); err != nil {
+			return nil, err
+		}
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: fd.key}, &v)
+	}
+	return n, nil
+}
+
+// presentKeys returns the setting keys of a YAML mapping.
+func presentKeys(n *yaml.Node) map[string]bool {
+	set := map[string]bool{}
+	if n.Kind != yaml.MappingNode {
+		return set
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if _, ok := lookup(n.Content[i].Value); ok {
+			set[n.Content[i].Value] = true
+		}
+	}
+	return set
+}
+
+// Defaults returns the built-in settings.
+func Defaults() Settings {
+	return Settings{
+		Addr:      DefaultAddr,
+		AdminAddr: DefaultAdminAddr,
+		Backend:   DefaultBackend,
+		UIAddr:    DefaultUIAddr,
+		Record:    append([]string(nil), DefaultRecord...),
+	}
+}
+
+// File is the configuration file format. Top-level settings apply to every
+// profile; a profile overrides them.
+type File struct {
+	Settings `yaml:",inline"`
+	// Profile is used when no profile is selected explicitly.
+	Profile  string              `yaml:"profile,omitempty"`
+	Profiles map[string]Settings `yaml:"profiles,omitempty"`
+}
+
+// UnmarshalYAML decodes a configuration file, giving the top-level settings
+// the same key tracking as profiles.
+func (f *File) UnmarshalYAML(n *yaml.Node) error {
+	var rest struct {
+		Profile  string              `yaml:"profile"`
+		Profiles map[string]Settings `yaml:"profiles"`
+	}
+	if err := n.Decode(&rest); err != nil {
+		return err
+	}
+	if err := n.Decode(&f.Settings); err != nil {
+		return err
+	}
+	f.Profile, f.Profiles = rest.Profile, rest.Profiles
+	return nil
+}
+
+// MarshalYAML encodes the top-level settings followed by the profiles.
+func (f File) MarshalYAML() (any, error) {
+	top, err := f.Settings.MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	n := top.(*yaml.Node)
+	var rest yaml.Node
+	err = rest.Encode(struct {
+		Profile  string              `yaml:"profile,omitempty"`
+		Profiles map[string]Settings `yaml:"profiles,omitempty"`
+	}{f.Profile, f.Profiles})
+	if err != nil {
+		return nil, err
+	}
+	n.Content = append(n.Content, rest.Content...)
+	return n, nil
+}
+
+// field describes one setting. ptr returns a *string, *[]string or *int.
+type field struct {
+	key string
+	ptr func(*Settings) any
+}
+
+var fields = []field{
+	{"addr", func(s *Settings) any { return &s.Addr }},
+	{"admin_addr", func(s *Settings) any { return &s.AdminAddr }},
+	{"backend", func(s *Settings) any { return &s.Backend }},
+	{"ui_addr", func(s *Settings) any { return &s.UIAddr }},
+	{"record", func(s *Settings) any { return &s.Record }},
+	{"redact", func(s *Settings) any { return &s.Redact }},
+	{"retention_days", func(s *Settings) any { return &s.RetentionDays }},
+	{"control_token", func(s *Settings) any { return &s.ControlToken }},
+	{"tls_cert", func(s *Settings) any { return &s.TLSCert }},
+	{"tls_key", func(s *Settings) any { return &s.TLSKey }},
+}
+
+// secret marks the settings whose values are masked when listed.
+var secret = map[string]bool{"control_token": true}
+
+// Keys returns the names of all settings.
+func Keys() []string {
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.key
+	}
+	return keys
+}
+
+func lookup(key string) (field, bool) {
+	for _, f := range fields {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// EnvVar returns the environment variable overriding a setting.
+func EnvVar(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// Path returns the location of the configuration file.
+func Path() (string, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Load reads a configuration file.
+func Load(file string) (*File, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return &f, nil
+}
+
+// LoadDefault reads config.yaml from the promptkit directory. A missing file
+// yields an empty configuration.
+func LoadDefault() (*File, error) {
+	file, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	f, err := Load(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &File{}, nil
+	}
+	return f, err
+}
+
+// Save writes f to file, creating its directory if needed.
+func (f *File) Save(file string) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// The file may hold control_token, so only its owner may read it.
+	if err := os.WriteFile(file, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0o600)
+}
+
+// Source tells where an effective setting came from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceProfile Source = "profile"
+	SourceEnv     Source = "env"
+)
+
+// Value is an effective setting.
+type Value struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source Source `json:"source"`
+}
+
+// ActiveProfile returns the profile to use: profile if set, otherwise
+// $PROMPTKIT_PROFILE, otherwise the file's default profile.
+func (f *File) ActiveProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if p := os.Getenv(EnvPrefix + "PROFILE"); p != "" {
+		return p
+	}
+	return f.Profile
+}
+
+// Resolve returns the effective settings of a profile: the built-in
+// defaults, overridden by the file's top-level settings, the profile and
+// finally environment variables. An empty profile selects the active one.
+func (f *File) Resolve(profile string) (Settings, error) {
+	s, _, err := f.resolve(profile)
+	return s, err
+}
+
+// Masked replaces the values of secret settings.
+const Masked = "********"
+
+// Effective lists every setting of a profile with its value and source.
+// Secret values are replaced with Masked.
+func (f *File) Effective(profile string) ([]Value, error) {
+	s, sources, err := f.resolve(profile)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, len(fields))
+	for i, fd := range fields {
+		v := format(fd.ptr(&s))
+		if secret[fd.key] && v != "" {
+			v = Masked
+		}
+		values[i] = Value{Key: fd.key, Value: v, Source: sources[fd.key]}
+	}
+	return values, nil
+}
+
+func (f *File) resolve(profile string) (Settings, map[string]Source, error) {
+	s := Defaults()
+	sources := map[string]Source{}
+	for _, fd := range fields {
+		sources[fd.key] = SourceDefault
+	}
+	overlay := func(layer *Settings, src Source) {
+		for _, fd := range fields {
+			if layer.has(fd) {
+				assign(fd.ptr(&s), fd.ptr(layer))
+				sources[fd.key] = src
+			}
+		}
+	}
+
+	overlay(&f.Settings, SourceFile)
+	if name := f.ActiveProfile(profile); name != "" {
+		p, ok := f.Profiles[name]
+		if !ok {
+			return Settings{}, nil, fmt.Errorf("unknown profile %q", name)
+		}
+		overlay(&p, SourceProfile)
+	}
+	var env Settings
+	for _, fd := range fields {
+		if v, ok := os.LookupEnv(EnvVar(fd.key)); ok {
+			values := []string{v}
+			if _, list := fd.ptr(&env).(*[]string); list && v != "" {
+				values = splitList(v)
+			}
+			if err := env.assign(fd, values); err != nil {
+				return Settings{}, nil, fmt.Errorf("%s: %w", EnvVar(fd.key), err)
+			}
+		}
+	}
+	overlay(&env, SourceEnv)
+	return s, sources, nil
+}
+
+// Get returns the value of key stored in the file, at the top level or in
+// profile, and whether it is set there.
+func (f *File) Get(profile, key string) (string, bool, error) {
+	fd, ok := lookup(key)
+	if !ok {
+		return "", false, unknownKey(key)
+	}
+	layer := &f.Settings
+	if profile != "" {
+		p, ok := f.Profiles[profile]
+		if !ok {
+			return "", false, fmt.Errorf("unknown profile %q", profile)
+		}
+		layer = &p
+	}
+	return format(fd.ptr(layer)), layer.has(fd), nil
+}
+
+// Set stores key at the top level or in profile, creating the profile if
+// needed. List settings take one value per element, and a single empty
+// value sets an empty list; no values unset key.
+func (f *File) Set(profile, key string, values []string) error {
+	fd, ok := lookup(key)
+	if !ok {
+		return unknownKey(key)
+	}
+	if profile == "" {
+		return f.Settings.assign(fd, values)
+	}
+	p := f.Profiles[profile]
+	if err := p.assign(fd, values); err != nil {
+		return err
+	}
+	if f.Profiles == nil {
+		f.Profiles = map[string]Settings{}
+	}
+	f.Profiles[profile] = p
+	return nil
+}
+
+// ProfileNames returns the profiles defined in the file, sorted.
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func unknownKey(key string) error {
+	return fmt.Errorf("unknown setting %q (want one of %s)", key, strings.Join(Keys(), ", "))
+}
+
+func isZero(p any) bool {
+	switch v := p.(type) {
+	case *string:
+		return *v == ""
+	case *[]string:
+		return len(*v) == 0
+	case *int:
+		return *v == 0
+	}
+	return true
+}
+
+func assign(dst, src any) {
+	switch d := dst.(type) {
+	case *string:
+		*d = *src.(*string)
+	case *[]string:
+		*d = append([]string(nil), *src.(*[]string)...)
+	case *int:
+		*d = *src.(*int)
+	}
+}
+
+func format(p any) string {
+	switch v := p.(type) {
+	case *string:
+		return *v
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *int:
+		return strconv.Itoa(*v)
+	}
+	return ""
+}
+
+// parse stores values into the setting p. Scalars take at most one value.
+func parse(p any, values []string) error {
+	switch v := p.(type) {
+	case *[]string:
+		*v = nil
+		for _, e := range values {
+			if e != "" {
+				*v = append(*v, e)
+			}
+		}
+		return nil
+	}
+	if len(values) > 1 {
+		return fmt.Errorf("expected a single value, got %d", len(values))
+	}
+	raw := ""
+	if len(values) == 1 {
+		raw = values[0]
+	}
+	switch v := p.(type) {
+	case *string:
+		*v = raw
+	case *int:
+		if raw == "" {
+			*v = 0
+			return nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*v = n
+	}
+	return nil
+}
+
+// splitList splits a comma-separated environment value.
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("PROMPTKIT_PROFILE", "")
+	f := &File{
+		Settings: Settings{Backend: "http://file", RetentionDays: 30},
+		Profiles: map[string]Settings{
+			"work": {Backend: "http://work", Record: []string{"/v1/embeddings"}},
+		},
+	}
+
+	s, err := f.Resolve("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr != DefaultAddr || s.Backend != "http://file" || s.RetentionDays != 30 || !slices.Equal(s.Record, DefaultRecord) {
+		t.Errorf("Resolve() = %+v", s)
+	}
+
+	s, _ = f.Resolve("work")
+	if s.Backend != "http://work" || !slices.Equal(s.Record, []string{"/v1/embeddings"}) || s.RetentionDays != 30 {
+		t.Errorf("Resolve(work) = %+v", s)
+	}
+	var quiet File
+	if err := yaml.Unmarshal([]byte("record: []\n"), &quiet); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = quiet.Resolve(""); len(s.Record) != 0 {
+		t.Errorf("explicitly empty record resolved to %v", s.Record)
+	}
+	if _, err := f.Resolve("missing"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+
+	t.Setenv("PROMPTKIT_PROFILE", "work")
+	t.Setenv("PROMPTKIT_ADDR", ":9000")
+	t.Setenv("PROMPTKIT_REDACT", "sk-[a-z]+, secret")
+	values, err := f.Effective("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Value{
+		"addr":           {"addr", ":9000", SourceEnv},
+		"backend":        {"backend", "http://work", SourceProfile},
+		"retention_days": {"retention_days", "30", SourceFile},
+		"ui_addr":        {"ui_addr", DefaultUIAddr, SourceDefault},
+		"redact":         {"redact", "sk-[a-z]+,secret", SourceEnv},
+	}
+	for _, v := range values {
+		if w, ok := want[v.Key]; ok && v != w {
+			t.Errorf("%s = %+v, want %+v", v.Key, v, w)
+		}
+	}
+
+	t.Setenv("PROMPTKIT_RETENTION_DAYS", "soon")
+	if _, err := f.Resolve(""); err == nil {
+		t.Error("invalid env number accepted")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	f := &File{}
+	if err := f.Set("", "backend", []string{"http://local"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("ci", "record", []string{"/v1/chat/completions", "/v1/embeddings"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("", "retention_days", []string{"x"}); err == nil {
+		t.Error("invalid number accepted")
+	}
+	if err := f.Set("", "colour", []string{"red"}); err == nil {
+		t.Error("unknown key accepted")
+	}
+	if err := f.Set("", "control_token", []string{"s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(file); err != nil || (runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600) {
+		t.Errorf("saved config mode = %v, %v", fi.Mode(), err)
+	}
+	loaded, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, _ := loaded.Get("", "backend"); !ok || v != "http://local" {
+		t.Errorf("Get(backend) = %q, %v", v, ok)
+	}
+	if v, ok, _ := loaded.Get("ci", "record"); !ok || v != "/v1/chat/completions,/v1/embeddings" {
+		t.Errorf("Get(ci, record) = %q, %v", v, ok)
+	}
+	if _, ok, _ := loaded.Get("", "addr"); ok {
+		t.Error("unset addr reported as set")
+	}
+	values, _ := loaded.Effective("")
+	for _, v := range values {
+		if v.Key == "control_token" && v.Value != Masked {
+			t.Errorf("control_token listed as %q", v.Value)
+		}
+	}
+
+	loaded.Set("", "backend", nil)
+	if _, ok, _ := loaded.Get("", "backend"); ok {
+		t.Error("backend still set after unsetting")
+	}
+}
+
+func TestExplicitEmpty(t *testing.T) {
+	t.Setenv("PROMPTKIT_PROFILE", "")
+	file := filepath.Join(t.TempDir(), FileName)
+	err := os.WriteFile(file, []byte(`
+admin_addr: localhost:9000
+retention_days: 7
+backend: http://file
+profiles:
+  quiet:
+    admin_addr: ""
+    retention_days: 0
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(f *File) {
+		t.Helper()
+		s, err := f.Resolve("quiet")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.AdminAddr != "" || s.RetentionDays != 0 || s.Backend != "http://file" {
+			t.Errorf("Resolve(quiet) = %+v", s)
+		}
+		if _, ok, _ := f.Get("quiet", "admin_addr"); !ok {
+			t.Error("empty admin_addr reported as unset")
+		}
+	}
+	check(f)
+
+	// Saving must keep the empty values.
+	if err := f.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = Load(file); err != nil {
+		t.Fatal(err)
+	}
+	check(f)
+
+	t.Setenv("PROMPTKIT_BACKEND", "")
+	if s, _ := f.Resolve("quiet"); s.Backend != "" {
+		t.Errorf("empty environment variable ignored: backend %q", s.Backend)
+	}
+}
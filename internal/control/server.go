@@ -1,56 +1,132 @@
 package control
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-chi/chi/v5"
 	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/auth"
 	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/internal/sessionfile"
+	"github.com/promptkit/promptkit/internal/stats"
+	"github.com/promptkit/promptkit/internal/thread"
+	"github.com/promptkit/promptkit/internal/trace"
 	"github.com/promptkit/promptkit/pkg/session"
 	"github.com/promptkit/promptkit/pkg/version"
 )
 
-// Broker sends session events to subscribers.
+// Event types sent on /events.
+const (
+	EventSession        = "session"         // a session was recorded or imported
+	EventSessionUpdated = "session.updated" // a session's tags or notes changed
+	EventSessionDeleted = "session.deleted" // data only carries the session id
+)
+
+const (
+	// RingSize is the number of recent events kept for clients resuming
+	// with Last-Event-ID.
+	RingSize = 1024
+	// HeartbeatInterval is how often idle event streams get a comment line,
+	// so proxies keep them open and clients notice dead connections.
+	HeartbeatInterval = 15 * time.Second
+	// clientBuffer is how many events a slow subscriber may fall behind
+	// before it is disconnected.
+	clientBuffer = 64
+)
+
+// Event is a typed message for subscribers. IDs increase by one per event.
+type Event struct {
+	ID   uint64
+	Type string
+	Data []byte // JSON
+}
+
+// Broker sends events to subscribers and keeps the most recent ones so
+// reconnecting clients can catch up.
 type Broker struct {
 	mu      sync.Mutex
-	clients map[chan session.Session]struct{}
+	clients map[chan Event]struct{}
+	ring    []Event // oldest first
+	size    int
+	last    uint64
 }
 
-func newBroker() *Broker {
-	return &Broker{clients: make(map[chan session.Session]struct{})}
+func newBroker(size int) *Broker {
+	return &Broker{clients: make(map[chan Event]struct{}), size: size}
 }
 
-func (b *Broker) Subscribe() chan session.Session {
-	ch := make(chan session.Session, 10)
+// Subscribe registers a subscriber and returns the retained events after
+// the event with ID after. A new subscriber, with after 0, already has the
+// sessions listed so far and gets no replay. If after is unknown, e.g.
+// because the server restarted, every retained event is returned.
+func (b *Broker) Subscribe(after uint64) (chan Event, []Event) {
+	ch := make(chan Event, clientBuffer)
 	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.clients[ch] = struct{}{}
-	b.mu.Unlock()
-	return ch
+	if after == 0 {
+		return ch, nil
+	}
+	if after > b.last {
+		after = 0
+	}
+	var replay []Event
+	for _, ev := range b.ring {
+		if ev.ID > after {
+			replay = append(replay, ev)
+		}
+	}
+	return ch, replay
 }
 
-func (b *Broker) Unsubscribe(ch chan session.Session) {
+// Unsubscribe removes a subscriber and closes its channel, unless the broker
+// already did so.
+func (b *Broker) Unsubscribe(ch chan Event) {
 	b.mu.Lock()
-	delete(b.clients, ch)
-	b.mu.Unlock()
-	close(ch)
+	defer b.mu.Unlock()
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
 }
 
+// Broadcast announces a new session.
 func (b *Broker) Broadcast(s session.Session) {
+	b.Publish(EventSession, s)
+}
+
+// Publish sends v as an event of the given type. Subscribers that fell too
+// far behind are disconnected rather than silently skipped; they resume from
+// the ring when they reconnect with Last-Event-ID.
+func (b *Broker) Publish(typ string, v any) Event {
+	data, _ := json.Marshal(v)
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.last++
+	ev := Event{ID: b.last, Type: typ, Data: data}
+	b.ring = append(b.ring, ev)
+	if len(b.ring) > b.size {
+		b.ring = b.ring[len(b.ring)-b.size:]
+	}
 	for ch := range b.clients {
 		select {
-		case ch <- s:
+		case ch <- ev:
 		default:
+			delete(b.clients, ch)
+			close(ch)
 		}
 	}
+	return ev
 }
 
 // Server holds state for the control-plane server.
@@ -59,41 +135,126 @@ type Server struct {
 	dir    string
 	broker *Broker
 	http   *http.Server
+	// heartbeat is the interval of keep-alive comments on /events.
+	heartbeat time.Duration
+	// rec records imported sessions; nil makes the API read-only.
+	rec   *recorder.Recorder
+	token string
+	tls   *tls.Config
+
+	mu    sync.Mutex
+	known map[string]struct{} // sessions already broadcast
+	edits sync.Mutex          // serializes rewrites of the session logs
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithDir serves the sessions in dir instead of the default sessions
+// directory.
+func WithDir(dir string) Option {
+	return func(s *Server) { s.dir = dir }
+}
+
+// WithRecorder enables the write endpoints. Imported sessions are recorded
+// with rec, which is paused while session logs are rewritten so edits do not
+// race with sessions being recorded. rec must be the only writer of the logs,
+// as only the daemon's recorder is: another process would keep appending to
+// a log after it has been replaced.
+func WithRecorder(rec *recorder.Recorder) Option {
+	return func(s *Server) { s.rec = rec }
+}
+
+// WithToken requires token as a bearer token on every request.
+func WithToken(token string) Option {
+	return func(s *Server) { s.token = token }
+}
+
+// WithTLS makes Start serve HTTPS with cfg.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *Server) { s.tls = cfg }
 }
 
 // NewServer creates a new Server for the given address.
-func NewServer(addr string) (*Server, error) {
+func NewServer(addr string, opts ...Option) (*Server, error) {
 	dir, err := appdir.SessionsDir()
 	if err != nil {
 		return nil, err
 	}
-	b := newBroker()
-	srv := &Server{addr: addr, dir: dir, broker: b}
+	b := newBroker(RingSize)
+	srv := &Server{addr: addr, dir: dir, broker: b, heartbeat: HeartbeatInterval, known: map[string]struct{}{}}
+	for _, opt := range opts {
+		opt(srv)
+	}
 
 	r := chi.NewRouter()
 	r.Get("/status", srv.handleStatus)
 	r.Get("/sessions", srv.handleSessions)
+	r.Post("/sessions", srv.handleImport)
+	r.Patch("/sessions", srv.handlePatchAll)
+	r.Delete("/sessions", srv.handleDeleteAll)
 	r.Get("/sessions/{id}", srv.handleSession)
+	r.Patch("/sessions/{id}", srv.handlePatch)
+	r.Delete("/sessions/{id}", srv.handleDelete)
+	r.Get("/stats", srv.handleStats)
+	r.Get("/threads/{id}", srv.handleThread)
+	r.Get("/traces/{id}", srv.handleTrace)
 	r.Get("/events", srv.handleEvents)
-	srv.http = &http.Server{Addr: addr, Handler: r}
+	srv.http = &http.Server{Addr: addr, Handler: auth.RequireToken(srv.token, r), TLSConfig: srv.tls}
 	return srv, nil
 }
 
+// Handler returns the HTTP API, for mounting it on another server.
+func (s *Server) Handler() http.Handler {
+	return s.http.Handler
+}
+
+// Publish broadcasts a session that was just recorded, without waiting for
+// the file watcher to notice it. Sessions already broadcast are skipped.
+func (s *Server) Publish(ss session.Session) {
+	s.announce(ss)
+}
+
+// announce broadcasts ss unless it was seen before. Session IDs have second
+// resolution, so the content hash tells sessions with equal IDs apart.
+func (s *Server) announce(ss session.Session) {
+	key := knownKey(ss)
+	s.mu.Lock()
+	_, seen := s.known[key]
+	s.known[key] = struct{}{}
+	s.mu.Unlock()
+	if !seen {
+		s.broker.Broadcast(ss)
+	}
+}
+
+func knownKey(ss session.Session) string {
+	return ss.ID + "/" + ss.Metadata.SessionHash
+}
+
 // Start begins watching sessions and serving HTTP.
 func (s *Server) Start() error {
-	if err := s.watch(); err != nil {
+	if err := s.Watch(); err != nil {
 		return err
 	}
 	go func() {
 		log.Printf("promptkit control server listening on %s", s.addr)
-		if err := s.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if s.tls != nil {
+			err = s.http.ListenAndServeTLS("", "")
+		} else {
+			err = s.http.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("server error: %v", err)
 		}
 	}()
 	return nil
 }
 
-func (s *Server) watch() error {
+// Watch broadcasts sessions appended to the session logs by any process.
+// Each log is read incrementally from where the previous read stopped.
+func (s *Server) Watch() error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return err
@@ -101,11 +262,26 @@ func (s *Server) watch() error {
 	if err := watcher.Add(s.dir); err != nil {
 		return err
 	}
-	known := map[string]struct{}{}
-	sessions, _ := list.LoadSessions(s.dir)
-	for _, ss := range sessions {
-		known[ss.ID] = struct{}{}
+	t := newTailer()
+	files, err := sessionfile.Files(s.dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, f := range files {
+		if !tailable(f) {
+			continue
+		}
+		sessions, err := t.read(f)
+		if err != nil {
+			log.Printf("read %s: %v", f, err)
+		}
+		for _, ss := range sessions {
+			s.known[knownKey(ss)] = struct{}{}
+		}
 	}
+	s.mu.Unlock()
+
 	go func() {
 		for {
 			select {
@@ -113,18 +289,20 @@ func (s *Server) watch() error {
 				if !ok {
 					return
 				}
-				if ev.Op&(fsnotify.Create|fsnotify.Write) != 0 && strings.HasSuffix(ev.Name, ".jsonl") {
-					sess, err := list.LoadSessions(s.dir)
+				if !tailable(ev.Name) {
+					continue
+				}
+				switch {
+				case ev.Op&(fsnotify.Create|fsnotify.Write) != 0:
+					sessions, err := t.read(ev.Name)
 					if err != nil {
-						log.Printf("load sessions: %v", err)
-						continue
+						log.Printf("read %s: %v", ev.Name, err)
 					}
-					for _, ss := range sess {
-						if _, exists := known[ss.ID]; !exists {
-							known[ss.ID] = struct{}{}
-							s.broker.Broadcast(ss)
-						}
+					for _, ss := range sessions {
+						s.announce(ss)
 					}
+				case ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+					t.forget(ev.Name)
 				}
 			case err := <-watcher.Errors:
 				if err != nil {
@@ -185,28 +363,113 @@ func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	sessions, err := list.LoadSessions(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pred, err := list.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, "invalid filter", http.StatusBadRequest)
+		return
+	}
+	var selected []session.Session
+	for _, ss := range sessions {
+		if pred(list.ToMap(ss)) {
+			selected = append(selected, ss)
+		}
+	}
+	groups, err := stats.Compute(selected, r.URL.Query().Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		stats.WriteCSV(w, groups)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (s *Server) handleThread(w http.ResponseWriter, r *http.Request) {
+	sessions, err := list.LoadSessions(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	th := thread.Find(sessions, chi.URLParam(r, "id"))
+	if th == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(th)
+}
+
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	sessions, err := list.LoadSessions(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tree := trace.Build(sessions, chi.URLParam(r, "id"))
+	if tree == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// handleEvents streams events as server-sent events. A client reconnecting
+// with a Last-Event-ID header, or a lastEventId query parameter, first gets
+// the retained events it missed.
 func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	after, _ := strconv.ParseUint(lastID, 10, 64)
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	ch := s.broker.Subscribe()
+	ch, replay := s.broker.Subscribe(after)
 	defer s.broker.Unsubscribe(ch)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 2000)
+	for _, ev := range replay {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
 
-	notify := r.Context().Done()
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
 	for {
 		select {
-		case s := <-ch:
-			b, _ := json.Marshal(s)
-			fmt.Fprintf(w, "event: session\n")
-			fmt.Fprintf(w, "data: %s\n\n", b)
+		case ev, ok := <-ch:
+			if !ok {
+				return // too slow; the client resumes from Last-Event-ID
+			}
+			writeEvent(w, ev)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
 			flusher.Flush()
-		case <-notify:
+		case <-r.Context().Done():
 			return
 		}
 	}
 }
+
+func writeEvent(w io.Writer, ev Event) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Data)
+}
@@ -0,0 +1,132 @@
+package control
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func eventIDs(events []Event) []uint64 {
+	var out []uint64
+	for _, ev := range events {
+		out = append(out, ev.ID)
+	}
+	return out
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := newBroker(3)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		b.Broadcast(session.Session{ID: id})
+	}
+
+	// New subscribers get no replay.
+	_, replay := b.Subscribe(0)
+	if len(replay) != 0 {
+		t.Errorf("replay for a new subscriber = %v", eventIDs(replay))
+	}
+	// Only the last three events are kept.
+	_, replay = b.Subscribe(1)
+	if got := eventIDs(replay); !slices.Equal(got, []uint64{2, 3, 4}) {
+		t.Errorf("replay after 1 = %v", got)
+	}
+	_, replay = b.Subscribe(3)
+	if got := eventIDs(replay); !slices.Equal(got, []uint64{4}) {
+		t.Errorf("replay after 3 = %v", got)
+	}
+	// An ID from before a restart replays everything retained.
+	_, replay = b.Subscribe(99)
+	if len(replay) != 3 {
+		t.Errorf("replay after unknown id = %v", eventIDs(replay))
+	}
+
+	ev := b.Publish(EventSessionDeleted, map[string]string{"id": "a"})
+	if ev.ID != 5 || string(ev.Data) != `{"id":"a"}` {
+		t.Errorf("Publish = %+v", ev)
+	}
+}
+
+func TestBrokerSlowClient(t *testing.T) {
+	b := newBroker(RingSize)
+	ch, _ := b.Subscribe(0)
+	for i := 0; i <= clientBuffer; i++ {
+		b.Broadcast(session.Session{ID: "s"})
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != clientBuffer {
+		t.Errorf("slow client got %d events before being dropped, want %d", n, clientBuffer)
+	}
+	b.Unsubscribe(ch) // already closed by the broker
+}
+
+func TestEventsResume(t *testing.T) {
+	s, err := NewServer("", WithDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.heartbeat = 20 * time.Millisecond
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	s.broker.Broadcast(session.Session{ID: "a"})
+	s.broker.Broadcast(session.Session{ID: "b"})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case l := <-lines:
+			return l
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return ""
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			for {
+				l := next()
+				if l == "" || strings.HasPrefix(l, "retry:") || strings.HasPrefix(l, ":") {
+					continue
+				}
+				if !strings.HasPrefix(l, w) {
+					t.Fatalf("line = %q, want prefix %q", l, w)
+				}
+				break
+			}
+		}
+	}
+
+	// Event 1 was seen already; only event 2 is replayed.
+	expect("id: 2", "event: session", `data: {"id":"b"`)
+	s.broker.Publish(EventSessionUpdated, session.Session{ID: "b"})
+	expect("id: 3", "event: session.updated")
+
+	for l := next(); l != ": heartbeat"; l = next() {
+	}
+}
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/promptkit/promptkit/internal/sessionfile"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// tailer reads the sessions appended to plain session logs since they were
+// last read, so live updates do not reparse the whole history. Compressed
+// logs are closed and never tailed.
+type tailer struct {
+	dec   *sessionfile.Decoder
+	files map[string]*tailPos
+}
+
+// tailPos is how far a file has been read: offset is just past the last
+// complete line.
+type tailPos struct {
+	info   os.FileInfo
+	offset int64
+}
+
+func newTailer() *tailer {
+	return &tailer{dec: sessionfile.NewDecoder(), files: map[string]*tailPos{}}
+}
+
+// tailable reports whether path is a log the tailer follows.
+func tailable(path string) bool {
+	return strings.HasSuffix(path, ".jsonl")
+}
+
+// read returns the complete sessions appended to path since the previous
+// read. A trailing line without a newline is still being written and is left
+// for the next read. A file that was truncated or replaced, e.g. by rekey, is
+// read again from the start.
+func (t *tailer) read(path string) ([]session.Session, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.forget(path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pos := t.files[path]
+	if pos == nil || !os.SameFile(pos.info, info) || info.Size() < pos.offset {
+		pos = &tailPos{}
+		t.files[path] = pos
+	}
+	pos.info = info
+	if info.Size() == pos.offset {
+		return nil, nil
+	}
+	if _, err := f.Seek(pos.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var sessions []session.Session
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				return sessions, nil // partial line, if any, is read next time
+			}
+			return sessions, err
+		}
+		pos.offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		s, err := t.dec.Decode(line)
+		if err != nil {
+			log.Printf("skip corrupt session in %s: %v", path, err)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+}
+
+// forget drops the position of a log that was removed or renamed.
+func (t *tailer) forget(path string) {
+	delete(t.files, path)
+}
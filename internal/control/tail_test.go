@@ -0,0 +1,85 @@
+package control
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func line(t *testing.T, id string) string {
+	t.Helper()
+	b, err := json.Marshal(session.Session{ID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b) + "\n"
+}
+
+func appendTo(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(sessions []session.Session) []string {
+	var out []string
+	for _, s := range sessions {
+		out = append(out, s.ID)
+	}
+	return out
+}
+
+func TestTailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat-2025-07-01.jsonl")
+	tl := newTailer()
+	read := func(want ...string) {
+		t.Helper()
+		got, err := tl.read(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := ids(got); !slices.Equal(g, want) {
+			t.Errorf("read = %v, want %v", g, want)
+		}
+	}
+
+	appendTo(t, path, line(t, "a")+line(t, "b"))
+	read("a", "b")
+	read()
+
+	// A line still being written is held back until it is complete.
+	c := line(t, "c")
+	appendTo(t, path, c[:10])
+	read()
+	appendTo(t, path, c[10:])
+	read("c")
+
+	// Truncation starts over.
+	os.WriteFile(path, []byte(line(t, "d")), 0o600)
+	read("d")
+
+	// So does replacing the file, even if it is larger.
+	tmp := path + ".tmp"
+	os.WriteFile(tmp, []byte(line(t, "d")+line(t, "e")+line(t, "f")), 0o600)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	read("d", "e", "f")
+
+	// Removed logs are forgotten.
+	os.Remove(path)
+	read()
+	if _, ok := tl.files[path]; ok {
+		t.Error("removed log still tracked")
+	}
+}
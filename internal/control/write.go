@@ -0,0 +1,408 @@
+package control
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/sessionfile"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// maxImport bounds the size of a POST /sessions body.
+const maxImport = 32 << 20
+
+//go:embed session.schema.json
+var sessionSchemaJSON string
+
+// sessionSchema validates sessions submitted to POST /sessions.
+var sessionSchema = jsonschema.MustCompileString("session.schema.json", sessionSchemaJSON)
+
+// Patch changes the tags or notes of sessions. Tags replaces all tags before
+// RemoveTags and AddTags are applied; nil fields are left alone.
+type Patch struct {
+	Tags       *[]string `json:"tags,omitempty"`
+	AddTags    []string  `json:"add_tags,omitempty"`
+	RemoveTags []string  `json:"remove_tags,omitempty"`
+	Notes      *string   `json:"notes,omitempty"`
+}
+
+func (p Patch) empty() bool {
+	return p.Tags == nil && p.AddTags == nil && p.RemoveTags == nil && p.Notes == nil
+}
+
+// apply edits s and reports whether anything changed.
+func (p Patch) apply(s *session.Session) bool {
+	tags := slices.Clone(s.Metadata.Tags)
+	if p.Tags != nil {
+		tags = slices.Clone(*p.Tags)
+	}
+	tags = slices.DeleteFunc(tags, func(t string) bool { return slices.Contains(p.RemoveTags, t) })
+	for _, t := range p.AddTags {
+		if !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	changed := !slices.Equal(tags, s.Metadata.Tags)
+	s.Metadata.Tags = tags
+	if p.Notes != nil && *p.Notes != s.Metadata.Notes {
+		s.Metadata.Notes = *p.Notes
+		changed = true
+	}
+	return changed
+}
+
+// BulkResult reports what a bulk PATCH or DELETE did.
+type BulkResult struct {
+	Matched int `json:"matched"`
+	Changed int `json:"changed"`
+}
+
+// validateSessions checks a POST /sessions body, a single session or an array
+// of them, against the session schema and decodes it.
+func validateSessions(body []byte) ([]session.Session, bool, error) {
+	body = bytes.TrimSpace(body)
+	many := len(body) > 0 && body[0] == '['
+	var raw []json.RawMessage
+	if many {
+		if err := json.Unmarshal(body, &raw); err != nil {
+			return nil, many, err
+		}
+	} else {
+		raw = []json.RawMessage{body}
+	}
+	sessions := make([]session.Session, len(raw))
+	for i, r := range raw {
+		var v any
+		if err := json.Unmarshal(r, &v); err != nil {
+			return nil, many, err
+		}
+		if err := sessionSchema.Validate(v); err != nil {
+			var verr *jsonschema.ValidationError
+			if errors.As(err, &verr) {
+				err = errors.New(strings.Join(schemaErrors(verr, nil), "; "))
+			}
+			if many {
+				err = fmt.Errorf("session %d: %w", i, err)
+			}
+			return nil, many, err
+		}
+		if err := json.Unmarshal(r, &sessions[i]); err != nil {
+			return nil, many, err
+		}
+	}
+	return sessions, many, nil
+}
+
+// schemaErrors flattens a validation error into its causes, each prefixed
+// with the JSON pointer of the offending value.
+func schemaErrors(verr *jsonschema.ValidationError, out []string) []string {
+	if len(verr.Causes) == 0 {
+		loc := verr.InstanceLocation
+		if loc == "" {
+			loc = "/"
+		}
+		return append(out, loc+": "+verr.Message)
+	}
+	for _, c := range verr.Causes {
+		out = schemaErrors(c, out)
+	}
+	return out
+}
+
+// prepareImport fills in what an imported session left out: an ID, origin
+// and timestamp like those of recorded sessions, and the session hash.
+func prepareImport(s *session.Session, now time.Time) {
+	if s.ID == "" {
+		s.ID = session.NewID(now)
+	}
+	if s.Origin == "" {
+		s.Origin = session.OriginManual
+	}
+	if s.Metadata.Timestamp.IsZero() {
+		s.Metadata.Timestamp = now
+	}
+	if hash, err := session.ComputeHash(*s); err == nil {
+		s.Metadata.SessionHash = hash
+	}
+}
+
+// handleImport records sessions created outside the proxy, e.g. written by
+// hand or exported from an agent framework.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImport))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	sessions, many, err := validateSessions(body)
+	if err != nil {
+		http.Error(w, "invalid session: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	for i := range sessions {
+		prepareImport(&sessions[i], now)
+		if err := s.rec.Record(sessions[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.announce(sessions[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if many {
+		json.NewEncoder(w).Encode(sessions)
+	} else {
+		json.NewEncoder(w).Encode(sessions[0])
+	}
+}
+
+// writable fails the request if the server has no recorder to write with.
+func (s *Server) writable(w http.ResponseWriter) bool {
+	if s.rec == nil {
+		http.Error(w, "sessions are read-only on this server", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	patch, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+	s.edits.Lock()
+	defer s.edits.Unlock()
+	match, ok := s.byID(w, r)
+	if !ok {
+		return
+	}
+	matched, _, err := s.patch(match, patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(matched) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched[0])
+}
+
+func (s *Server) handlePatchAll(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	pred, ok := bulkFilter(w, r)
+	if !ok {
+		return
+	}
+	patch, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+	s.edits.Lock()
+	defer s.edits.Unlock()
+	matched, changed, err := s.patch(pred, patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BulkResult{Matched: len(matched), Changed: changed})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	s.edits.Lock()
+	defer s.edits.Unlock()
+	match, ok := s.byID(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := s.delete(match)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deleted == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteAll(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	pred, ok := bulkFilter(w, r)
+	if !ok {
+		return
+	}
+	s.edits.Lock()
+	defer s.edits.Unlock()
+	deleted, err := s.delete(pred)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BulkResult{Matched: deleted, Changed: deleted})
+}
+
+// byID returns a predicate selecting the session a by-ID request names: the
+// one with the ID in the path and, if given, the session hash in ?hash=.
+// Recorded IDs have second resolution, so an ID alone may be ambiguous; the
+// request then fails with 409 rather than editing several sessions. s.edits
+// must be held so the match cannot change before the edit.
+func (s *Server) byID(w http.ResponseWriter, r *http.Request) (func(session.Session) bool, bool) {
+	id, hash := chi.URLParam(r, "id"), r.URL.Query().Get("hash")
+	match := func(ss session.Session) bool {
+		return ss.ID == id && (hash == "" || ss.Metadata.SessionHash == hash)
+	}
+	sessions, err := list.LoadSessions(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	n := 0
+	for _, ss := range sessions {
+		if match(ss) {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		http.NotFound(w, r)
+		return nil, false
+	case n > 1:
+		http.Error(w, fmt.Sprintf("%d sessions have ID %s; select one with ?hash=<session_hash>", n, id), http.StatusConflict)
+		return nil, false
+	}
+	return match, true
+}
+
+// bulkFilter parses the filter of a bulk operation. Unlike listing, bulk
+// operations require one, so a missing parameter cannot touch every session.
+func bulkFilter(w http.ResponseWriter, r *http.Request) (func(session.Session) bool, bool) {
+	expr := r.URL.Query().Get("filter")
+	if expr == "" {
+		http.Error(w, "filter required", http.StatusBadRequest)
+		return nil, false
+	}
+	pred, err := list.ParseFilter(expr)
+	if err != nil {
+		http.Error(w, "invalid filter", http.StatusBadRequest)
+		return nil, false
+	}
+	return func(ss session.Session) bool { return pred(list.ToMap(ss)) }, true
+}
+
+func decodePatch(w http.ResponseWriter, r *http.Request) (Patch, bool) {
+	var p Patch
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, "invalid patch: "+err.Error(), http.StatusBadRequest)
+		return p, false
+	}
+	if p.empty() {
+		http.Error(w, "invalid patch: nothing to change", http.StatusBadRequest)
+		return p, false
+	}
+	return p, true
+}
+
+// patch applies p to the sessions matching match and returns them, along
+// with how many actually changed. Changed sessions get a new hash and are
+// announced as updated.
+func (s *Server) patch(match func(session.Session) bool, p Patch) ([]session.Session, int, error) {
+	var matched, changed []session.Session
+	err := s.rewrite(func(ss *session.Session) sessionfile.Edit {
+		if !match(*ss) {
+			return sessionfile.Keep
+		}
+		edit := sessionfile.Keep
+		if p.apply(ss) {
+			if hash, err := session.ComputeHash(*ss); err == nil {
+				ss.Metadata.SessionHash = hash
+			}
+			// The rewritten log is read again by the watcher; it must not
+			// announce the edited session as a new one.
+			s.mu.Lock()
+			s.known[knownKey(*ss)] = struct{}{}
+			s.mu.Unlock()
+			changed = append(changed, *ss)
+			edit = sessionfile.Update
+		}
+		matched = append(matched, *ss)
+		return edit
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, ss := range changed {
+		s.broker.Publish(EventSessionUpdated, ss)
+	}
+	return matched, len(changed), nil
+}
+
+// delete removes the sessions matching match from the logs and returns how
+// many there were.
+func (s *Server) delete(match func(session.Session) bool) (int, error) {
+	var deleted []string
+	err := s.rewrite(func(ss *session.Session) sessionfile.Edit {
+		if !match(*ss) {
+			return sessionfile.Keep
+		}
+		deleted = append(deleted, ss.ID)
+		return sessionfile.Drop
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range deleted {
+		s.broker.Publish(EventSessionDeleted, map[string]string{"id": id})
+	}
+	return len(deleted), nil
+}
+
+// rewrite passes every session in the logs through fn, pausing the recorder
+// while the logs are replaced. s.edits must be held.
+func (s *Server) rewrite(fn sessionfile.EditFunc) error {
+	files, err := sessionfile.Files(s.dir)
+	if err != nil {
+		return err
+	}
+	dec := sessionfile.NewDecoder()
+	return s.rec.Pause(func() error {
+		for _, f := range files {
+			if _, err := sessionfile.Rewrite(f, dec, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
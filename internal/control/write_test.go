@@ -0,0 +1,192 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestWriteEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := recorder.New(filepath.Join(dir, "chat-2025-07-01.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	s, err := NewServer("", WithDir(dir), WithRecorder(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	ch, _ := s.broker.Subscribe(0)
+
+	load := func() []session.Session {
+		t.Helper()
+		sessions, err := list.LoadSessions(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sessions
+	}
+	event := func(typ string) Event {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			if ev.Type != typ {
+				t.Fatalf("event = %s %s, want %s", ev.Type, ev.Data, typ)
+			}
+			return ev
+		default:
+			t.Fatalf("no %s event", typ)
+			return Event{}
+		}
+	}
+
+	// Invalid sessions are rejected before anything is recorded.
+	for _, body := range []string{
+		`{"id":"x"}`,
+		`{"request":{"messages":[{"role":"user"}]}}`,
+		`[{"request":{"prompt":"hi"}},{"request":{"prompt":"hi"},"extra":1}]`,
+	} {
+		if code, msg := do(t, http.MethodPost, srv.URL+"/sessions", body); code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d %s, want 400", body, code, msg)
+		}
+	}
+	if n := len(load()); n != 0 {
+		t.Fatalf("%d sessions recorded from invalid payloads", n)
+	}
+
+	code, body := do(t, http.MethodPost, srv.URL+"/sessions", `[
+		{"id":"a","origin":"framework","request":{"model":"gpt","messages":[{"role":"user","content":"hi"}]},"metadata":{"tags":["qa"]}},
+		{"id":"b","request":{"prompt":"hello"}}
+	]`)
+	if code != http.StatusCreated {
+		t.Fatalf("POST /sessions = %d %s", code, body)
+	}
+	var created []session.Session
+	json.Unmarshal([]byte(body), &created)
+	if len(created) != 2 || created[1].Origin != session.OriginManual || created[1].Metadata.SessionHash == "" {
+		t.Fatalf("created = %s", body)
+	}
+	event(EventSession)
+	event(EventSession)
+
+	// Sessions imported together without IDs get distinct ones.
+	code, body = do(t, http.MethodPost, srv.URL+"/sessions", `[{"request":{"prompt":"x"}},{"request":{"prompt":"y"}}]`)
+	var unnamed []session.Session
+	json.Unmarshal([]byte(body), &unnamed)
+	if code != http.StatusCreated || len(unnamed) != 2 || unnamed[0].ID == "" || unnamed[0].ID == unnamed[1].ID {
+		t.Fatalf("POST without IDs = %d %s", code, body)
+	}
+	event(EventSession)
+	event(EventSession)
+	code, body = do(t, http.MethodDelete, srv.URL+"/sessions?filter=id="+unnamed[0].ID, "")
+	if code != http.StatusOK || strings.TrimSpace(body) != `{"matched":1,"changed":1}` {
+		t.Fatalf("DELETE of one imported session = %d %s", code, body)
+	}
+	event(EventSessionDeleted)
+	do(t, http.MethodDelete, srv.URL+"/sessions/"+unnamed[1].ID, "")
+	event(EventSessionDeleted)
+
+	code, body = do(t, http.MethodPatch, srv.URL+"/sessions/a", `{"add_tags":["reviewed"],"remove_tags":["qa"],"notes":"looks good"}`)
+	if code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", code, body)
+	}
+	var a session.Session
+	json.Unmarshal([]byte(body), &a)
+	if !slices.Equal(a.Metadata.Tags, []string{"reviewed"}) || a.Metadata.Notes != "looks good" || a.Metadata.SessionHash == created[0].Metadata.SessionHash {
+		t.Errorf("patched session = %s", body)
+	}
+	event(EventSessionUpdated)
+	if code, _ := do(t, http.MethodPatch, srv.URL+"/sessions/a", `{"color":"red"}`); code != http.StatusBadRequest {
+		t.Errorf("PATCH with unknown field = %d", code)
+	}
+	if code, _ := do(t, http.MethodPatch, srv.URL+"/sessions/nope", `{"notes":""}`); code != http.StatusNotFound {
+		t.Errorf("PATCH of missing session = %d", code)
+	}
+
+	// Bulk operations need a filter.
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/sessions", ""); code != http.StatusBadRequest {
+		t.Errorf("DELETE without filter = %d", code)
+	}
+	code, body = do(t, http.MethodPatch, srv.URL+"/sessions?filter=origin=manual", `{"tags":["bulk"]}`)
+	if code != http.StatusOK || strings.TrimSpace(body) != `{"matched":1,"changed":1}` {
+		t.Errorf("bulk PATCH = %d %s", code, body)
+	}
+	event(EventSessionUpdated)
+
+	// Sessions recorded while the log is rewritten are not lost.
+	rec.Record(session.Session{ID: "c"})
+
+	// An ID shared by several sessions needs their hash to pick one.
+	rec.Record(session.Session{ID: "twin", Metadata: session.Metadata{SessionHash: "h1"}})
+	rec.Record(session.Session{ID: "twin", Metadata: session.Metadata{SessionHash: "h2"}})
+	if code, _ := do(t, http.MethodPatch, srv.URL+"/sessions/twin", `{"notes":"which?"}`); code != http.StatusConflict {
+		t.Errorf("PATCH of an ambiguous ID = %d", code)
+	}
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/sessions/twin", ""); code != http.StatusConflict {
+		t.Errorf("DELETE of an ambiguous ID = %d", code)
+	}
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/sessions/twin?hash=h1", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE by ID and hash = %d", code)
+	}
+	event(EventSessionDeleted)
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/sessions/twin", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE of the remaining twin = %d", code)
+	}
+	event(EventSessionDeleted)
+
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/sessions/a", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", code)
+	}
+	if ev := event(EventSessionDeleted); string(ev.Data) != `{"id":"a"}` {
+		t.Errorf("deleted event = %s", ev.Data)
+	}
+	rec.Record(session.Session{ID: "d"})
+
+	var ids []string
+	for _, ss := range load() {
+		ids = append(ids, ss.ID)
+		if ss.ID == "b" && !slices.Equal(ss.Metadata.Tags, []string{"bulk"}) {
+			t.Errorf("bulk patched session = %+v", ss.Metadata)
+		}
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"b", "c", "d"}) {
+		t.Errorf("sessions after delete = %v", ids)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s, err := NewServer("", WithDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	if code, _ := do(t, http.MethodPost, srv.URL+"/sessions", `{"request":{"prompt":"hi"}}`); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST without recorder = %d", code)
+	}
+}
@@ -0,0 +1,264 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+)
+
+// PassphraseEnv names the environment variable holding the passphrase for
+// passphrase-protected keys.
+const PassphraseEnv = "PROMPTKIT_PASSPHRASE"
+
+// KeyFileName is the name of the key file under the promptkit directory.
+const KeyFileName = "session.key"
+
+const (
+	linePrefix = "enc1:"
+	kdfNone    = "none"
+	kdfPBKDF2  = "pbkdf2-sha256"
+	iterations = 600_000
+	checkText  = "promptkit"
+)
+
+// ErrNoKey is returned when encrypted data is found but no key is configured.
+var ErrNoKey = errors.New("no encryption key configured")
+
+// Key encrypts and decrypts session log lines.
+type Key struct {
+	ID   string
+	aead cipher.AEAD
+	file keyFile
+}
+
+// keyFile is the on-disk representation of a key. Random keys are stored
+// directly; passphrase keys only store the salt and a check value.
+type keyFile struct {
+	ID         string `json:"id"`
+	KDF        string `json:"kdf"`
+	Key        string `json:"key,omitempty"`
+	Salt       string `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Check      string `json:"check"`
+}
+
+// KeyPath returns the default key file location.
+func KeyPath() (string, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, KeyFileName), nil
+}
+
+// Generate creates a new key. If passphrase is empty a random key is used,
+// otherwise the key is derived from the passphrase with PBKDF2.
+func Generate(passphrase string) (*Key, error) {
+	raw := make([]byte, 32)
+	kf := keyFile{KDF: kdfNone}
+	if passphrase == "" {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		kf.Key = base64.StdEncoding.EncodeToString(raw)
+	} else {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		var err error
+		raw, err = pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+		kf.KDF = kdfPBKDF2
+		kf.Salt = base64.StdEncoding.EncodeToString(salt)
+		kf.Iterations = iterations
+	}
+	sum := sha256.Sum256(raw)
+	kf.ID = hex.EncodeToString(sum[:4])
+
+	k, err := newKey(kf.ID, raw)
+	if err != nil {
+		return nil, err
+	}
+	kf.Check = string(k.Seal([]byte(checkText)))
+	k.file = kf
+	return k, nil
+}
+
+// Load reads the key file at path. Passphrase keys are unlocked with the
+// given passphrase. It returns an error wrapping os.ErrNotExist if the file
+// does not exist.
+func Load(path, passphrase string) (*Key, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("parse key file: %w", err)
+	}
+
+	var raw []byte
+	switch kf.KDF {
+	case kdfNone:
+		raw, err = base64.StdEncoding.DecodeString(kf.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decode key: %w", err)
+		}
+	case kdfPBKDF2:
+		if passphrase == "" {
+			return nil, fmt.Errorf("key is passphrase protected; set %s", PassphraseEnv)
+		}
+		salt, err := base64.StdEncoding.DecodeString(kf.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("decode salt: %w", err)
+		}
+		raw, err = pbkdf2.Key(sha256.New, passphrase, salt, kf.Iterations, 32)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kdf %q", kf.KDF)
+	}
+
+	k, err := newKey(kf.ID, raw)
+	if err != nil {
+		return nil, err
+	}
+	if plain, err := k.Open([]byte(kf.Check)); err != nil || string(plain) != checkText {
+		return nil, fmt.Errorf("wrong passphrase or corrupt key file")
+	}
+	k.file = kf
+	return k, nil
+}
+
+// LoadDefault loads the key from KeyPath using the passphrase from
+// PassphraseEnv. It returns nil, nil if no key file exists.
+func LoadDefault() (*Key, error) {
+	path, err := KeyPath()
+	if err != nil {
+		return nil, err
+	}
+	k, err := Load(path, os.Getenv(PassphraseEnv))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return k, err
+}
+
+// Save writes the key file to path with owner-only permissions.
+func (k *Key) Save(path string) error {
+	b, err := json.MarshalIndent(k.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newKey(id string, raw []byte) (*Key, error) {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: id, aead: aead}, nil
+}
+
+// Seal encrypts plain into a single text line of the form
+// "enc1:<key-id>:<base64(nonce|ciphertext)>" without a trailing newline.
+func (k *Key) Seal(plain []byte) []byte {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	ct := k.aead.Seal(nonce, nonce, plain, []byte(k.ID))
+	out := make([]byte, 0, len(linePrefix)+len(k.ID)+1+base64.StdEncoding.EncodedLen(len(ct)))
+	out = append(out, linePrefix...)
+	out = append(out, k.ID...)
+	out = append(out, ':')
+	return base64.StdEncoding.AppendEncode(out, ct)
+}
+
+// Open decrypts a line produced by Seal.
+func (k *Key) Open(line []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte(linePrefix))
+	if !ok {
+		return nil, fmt.Errorf("not an encrypted line")
+	}
+	id, data, ok := bytes.Cut(rest, []byte(":"))
+	if !ok {
+		return nil, fmt.Errorf("malformed encrypted line")
+	}
+	if string(id) != k.ID {
+		return nil, fmt.Errorf("line encrypted with key %s, have %s", id, k.ID)
+	}
+	ct, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	ns := k.aead.NonceSize()
+	if len(ct) < ns {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return k.aead.Open(nil, ct[:ns], ct[ns:], id)
+}
+
+// LineKeyID returns the ID of the key an encrypted line was sealed with.
+func LineKeyID(line []byte) string {
+	rest, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte(linePrefix))
+	if !ok {
+		return ""
+	}
+	id, _, _ := bytes.Cut(rest, []byte(":"))
+	return string(id)
+}
+
+// IsEncrypted reports whether line was produced by Seal.
+func IsEncrypted(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(line), []byte(linePrefix))
+}
+
+// LoadOrCreate loads the default key, generating and saving a new one if none
+// exists yet.
+func LoadOrCreate() (*Key, error) {
+	k, err := LoadDefault()
+	if err != nil || k != nil {
+		return k, err
+	}
+	k, err = Generate(os.Getenv(PassphraseEnv))
+	if err != nil {
+		return nil, err
+	}
+	path, err := KeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Save(path); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
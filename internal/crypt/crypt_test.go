@@ -0,0 +1,57 @@
+package crypt
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	k, err := Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := k.Seal([]byte(`{"id":"1"}`))
+	if !IsEncrypted(line) {
+		t.Fatalf("expected encrypted line: %s", line)
+	}
+	if LineKeyID(line) != k.ID {
+		t.Fatalf("unexpected key id %q", LineKeyID(line))
+	}
+	plain, err := k.Open(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != `{"id":"1"}` {
+		t.Fatalf("unexpected plaintext %s", plain)
+	}
+
+	other, _ := Generate("")
+	if _, err := other.Open(line); err == nil {
+		t.Fatalf("expected error opening with another key")
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), KeyFileName)
+	k, err := Generate("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Open(k.Seal([]byte("hi"))); err != nil {
+		t.Fatalf("loaded key cannot open: %v", err)
+	}
+	if _, err := Load(path, "wrong"); err == nil {
+		t.Fatalf("expected wrong passphrase error")
+	}
+	if _, err := Load(path, ""); err == nil {
+		t.Fatalf("expected missing passphrase error")
+	}
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/promptkit/promptkit/internal/metrics"
+)
+
+// newAdminRouter returns the routes served on the daemon's admin address.
+// The lifecycle endpoints are only served when lc is non-nil, and every other
+// path is passed to the control API when api is non-nil.
+func newAdminRouter(m *metrics.Metrics, lc *lifecycle, api http.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(sameOrigin)
+	r.Handle("/metrics", m.Handler())
+	if lc != nil {
+		r.Get("/status", lc.handleStatus)
+		r.Post("/shutdown", lc.handleShutdown)
+	}
+	if api != nil {
+		r.Mount("/", api)
+	}
+	return r
+}
+
+// sameOrigin rejects requests that change state when a web page on another
+// origin sent them. Browsers send a bodyless or text/plain POST without a
+// preflight, so any site open in the user's browser could otherwise stop the
+// daemon; promptkit's own clients send no Origin header.
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					http.Error(w, "cross-origin request rejected", http.StatusForbidden)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,32 +1,389 @@
 package daemon
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/auth"
+	"github.com/promptkit/promptkit/internal/budget"
+	"github.com/promptkit/promptkit/internal/cache"
+	"github.com/promptkit/promptkit/internal/clients"
+	"github.com/promptkit/promptkit/internal/control"
+	"github.com/promptkit/promptkit/internal/crypt"
+	"github.com/promptkit/promptkit/internal/fault"
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/metrics"
+	"github.com/promptkit/promptkit/internal/otlp"
+	"github.com/promptkit/promptkit/internal/pricing"
+	"github.com/promptkit/promptkit/internal/ratelimit"
 	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/internal/redact"
+	"github.com/promptkit/promptkit/internal/retry"
+	"github.com/promptkit/promptkit/internal/route"
+	"github.com/promptkit/promptkit/internal/sessionfile"
 )
 
+// Config holds the daemon settings.
+type Config struct {
+	Addr    string
+	Backend string
+	// AdminAddr is the listen address of the admin server exposing /metrics.
+	// It is separate from Addr so admin endpoints are never proxied. Empty
+	// disables it.
+	AdminAddr string
+	// Control also serves the control API used by the UI on AdminAddr, and
+	// pushes recorded sessions to its event stream as they are written.
+	Control bool
+	// Encrypt enables encryption at rest, creating a key if none exists.
+	// Sessions are always encrypted once a key file is present.
+	Encrypt bool
+	// Compress gzips session logs once they have been rotated out.
+	Compress bool
+	// PricingFile is a YAML pricing table used to compute session costs. If
+	// empty, pricing.yaml in the promptkit directory or the built-in table is
+	// used.
+	PricingFile string
+	// BudgetFile is a YAML file of daily token and cost budgets. If empty,
+	// budgets.yaml in the promptkit directory is used when present.
+	BudgetFile string
+	// LimitsFile is a YAML file of per-backend and per-model rate limits. If
+	// empty, limits.yaml in the promptkit directory is used when present.
+	LimitsFile string
+	// Retry is applied to upstream failures of requests that are safe to
+	// repeat.
+	Retry retry.Policy
+	// RoutesFile is a YAML routing table mapping models and paths to
+	// backends with fallbacks. If empty, routes.yaml in the promptkit
+	// directory is used when present. Unrouted requests go to Backend.
+	RoutesFile string
+	// Cache serves repeated deterministic requests from recorded sessions.
+	Cache cache.Config
+	// FaultsFile enables fault injection with the failures it configures.
+	FaultsFile string
+	// Record lists the POST endpoints whose calls are recorded. It is used
+	// as given; resolved settings default it to config.DefaultRecord.
+	Record []string
+	// Redact lists regular expressions masked in recorded sessions.
+	Redact []string
+	// RetentionDays deletes session logs older than this many days on
+	// startup and at every rotation. Zero keeps them forever.
+	RetentionDays int
+	// ShutdownTimeout bounds how long in-flight requests are drained on
+	// SIGTERM or an admin shutdown. Zero means DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// OTLP exports every recorded session as a span when Endpoint is set.
+	OTLP otlp.Config
+	// ClientsFile maps the API keys of local clients to the client names
+	// recorded with their sessions. If empty, clients.yaml in the promptkit
+	// directory is used when present.
+	ClientsFile string
+	// ControlToken, if set, must be sent as a bearer token to every admin
+	// endpoint, including the control API.
+	ControlToken string
+	// TLSCert and TLSKey are PEM files both listeners serve HTTPS with.
+	TLSCert string
+	TLSKey  string
+}
+
 // Run starts the promptkit daemon and blocks until the HTTP server exits.
-func Run(addr, backend string) error {
+func Run(cfg Config) error {
+	tlsConfig, err := auth.ServerTLS(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return err
+	}
 	logPath, err := appdir.SessionLogPath()
 	if err != nil {
 		return fmt.Errorf("session path: %w", err)
 	}
+	dir := filepath.Dir(logPath)
+
+	key, err := loadKey(cfg.Encrypt)
+	if err != nil {
+		return fmt.Errorf("encryption key: %w", err)
+	}
+	var opts []recorder.Option
+	if key != nil {
+		log.Printf("encrypting sessions with key %s", key.ID)
+		opts = append(opts, recorder.WithKey(key))
+	}
+	opts = append(opts, recorder.OnRotate(func(closed string) {
+		if cfg.Compress {
+			compressLog(closed)
+		}
+		prune(dir, cfg.RetentionDays, time.Now())
+	}))
+	prune(dir, cfg.RetentionDays, time.Now())
 
-	rec, err := recorder.New(logPath)
+	rec, err := recorder.NewDaily(dir, appdir.SessionLogName, opts...)
 	if err != nil {
 		return fmt.Errorf("recorder: %w", err)
 	}
 	defer rec.Close()
 
-	handler, err := newHandler(backend, rec)
+	if cfg.Compress {
+		go compressClosedLogs(dir, rec.Path())
+	}
+
+	handler, err := newHandler(cfg.Backend, rec)
 	if err != nil {
 		return fmt.Errorf("handler: %w", err)
 	}
+	if cfg.PricingFile != "" {
+		handler.pricing, err = pricing.Load(cfg.PricingFile)
+	} else {
+		handler.pricing, err = pricing.LoadDefault()
+	}
+	if err != nil {
+		return fmt.Errorf("pricing: %w", err)
+	}
+	if cfg.BudgetFile != "" {
+		handler.budgets, err = budget.Load(cfg.BudgetFile)
+	} else {
+		handler.budgets, err = budget.LoadDefault()
+	}
+	if err != nil {
+		return fmt.Errorf("budgets: %w", err)
+	}
+	if cfg.RoutesFile != "" {
+		handler.routes, err = route.Load(cfg.RoutesFile, cfg.Backend)
+	} else {
+		handler.routes, err = route.LoadDefault(cfg.Backend)
+	}
+	if err != nil {
+		return fmt.Errorf("routes: %w", err)
+	}
+	handler.retry = cfg.Retry
+	handler.recordPaths = cfg.Record
+	if handler.redact, err = redact.New(cfg.Redact); err != nil {
+		return err
+	}
+	if cfg.FaultsFile != "" {
+		if handler.faults, err = fault.Load(cfg.FaultsFile); err != nil {
+			return fmt.Errorf("faults: %w", err)
+		}
+		log.Printf("injecting faults from %s", cfg.FaultsFile)
+	}
+	if cfg.Cache.Enabled {
+		handler.cache = cache.New(cfg.Cache)
+	}
+	if cfg.LimitsFile != "" {
+		handler.limits, err = ratelimit.Load(cfg.LimitsFile)
+	} else {
+		handler.limits, err = ratelimit.LoadDefault()
+	}
+	if err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if cfg.ClientsFile != "" {
+		handler.clients, err = clients.Load(cfg.ClientsFile)
+	} else {
+		handler.clients, err = clients.LoadDefault()
+	}
+	if err != nil {
+		return fmt.Errorf("clients: %w", err)
+	}
+	if err := seed(handler, dir); err != nil {
+		log.Printf("load recorded sessions: %v", err)
+	}
+	if cfg.OTLP.Endpoint != "" {
+		exporter := otlp.NewExporter(cfg.OTLP)
+		defer exporter.Close()
+		handler.observers = append(handler.observers, exporter.Export)
+		log.Printf("exporting sessions to %s", cfg.OTLP.Endpoint)
+	}
 
-	log.Printf("promptkit listening on %s", addr)
-	return http.ListenAndServe(addr, handler)
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	srv := &http.Server{Handler: handler}
+	state := State{
+		PID:       os.Getpid(),
+		Addr:      cfg.Addr,
+		AdminAddr: cfg.AdminAddr,
+		Control:   cfg.Control && cfg.AdminAddr != "",
+		TLS:       tlsConfig != nil,
+		StartedAt: time.Now(),
+	}
+	lc := newLifecycle(state, handler)
+
+	if cfg.AdminAddr != "" {
+		var api http.Handler
+		if state.Control {
+			ctl, err := startControl(cfg.AdminAddr, dir, rec)
+			if err != nil {
+				ln.Close()
+				return fmt.Errorf("control: %w", err)
+			}
+			handler.observers = append(handler.observers, ctl.Publish)
+			api = ctl.Handler()
+			log.Printf("serving the control API on %s", cfg.AdminAddr)
+		}
+		adminLn, err := net.Listen("tcp", cfg.AdminAddr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("admin: %w", err)
+		}
+		if tlsConfig != nil {
+			adminLn = tls.NewListener(adminLn, tlsConfig)
+		}
+		handler.metrics = metrics.New()
+		admin := &http.Server{Handler: auth.RequireToken(cfg.ControlToken, newAdminRouter(handler.metrics, lc, api))}
+		defer admin.Close()
+		go func() {
+			log.Printf("promptkit admin listening on %s", cfg.AdminAddr)
+			if err := admin.Serve(adminLn); err != nil && err != http.ErrServerClosed {
+				log.Printf("admin server error: %v", err)
+			}
+		}()
+	}
+
+	if err := writeState(state); err != nil {
+		ln.Close()
+		return err
+	}
+	defer removeOwnState(state.PID)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	log.Printf("promptkit listening on %s (pid %d)", cfg.Addr, state.PID)
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	case <-lc.stopped:
+	}
+	return shutdown(srv, handler, cfg.ShutdownTimeout)
+}
+
+// startControl returns a control server for the sessions in dir that also
+// picks up sessions other processes append to the logs. Sessions imported
+// through it are recorded with rec.
+func startControl(addr, dir string, rec *recorder.Recorder) (*control.Server, error) {
+	ctl, err := control.NewServer(addr, control.WithDir(dir), control.WithRecorder(rec))
+	if err != nil {
+		return nil, err
+	}
+	if err := ctl.Watch(); err != nil {
+		return nil, err
+	}
+	return ctl, nil
+}
+
+// shutdown stops accepting connections and waits up to timeout for in-flight
+// requests to finish, so their sessions are recorded before the recorder is
+// closed.
+func shutdown(srv *http.Server, h *handler, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	log.Printf("shutting down, draining %d in-flight requests", h.inFlight.Load())
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	log.Printf("promptkit stopped")
+	return nil
+}
+
+func loadKey(create bool) (*crypt.Key, error) {
+	if create {
+		return crypt.LoadOrCreate()
+	}
+	return crypt.LoadDefault()
+}
+
+func compressLog(path string) {
+	if err := sessionfile.Compress(path); err != nil {
+		log.Printf("compress %s: %v", path, err)
+	}
+}
+
+// compressClosedLogs compresses plain logs left behind by earlier runs,
+// skipping the file currently being written.
+func compressClosedLogs(dir, current string) {
+	files, err := filepath.Glob(filepath.Join(dir, "chat-*.jsonl"))
+	if err != nil {
+		log.Printf("compress: %v", err)
+		return
+	}
+	for _, f := range files {
+		if f != current {
+			compressLog(f)
+		}
+	}
+}
+
+// prune deletes session logs in dir older than days days. Logs are dated by
+// their file name.
+func prune(dir string, days int, now time.Time) {
+	if days <= 0 {
+		return
+	}
+	files, err := sessionfile.Files(dir)
+	if err != nil {
+		log.Printf("retention: %v", err)
+		return
+	}
+	y, m, d := now.Date()
+	cutoff := time.Date(y, m, d-days, 0, 0, 0, 0, now.Location())
+	for _, f := range files {
+		day, ok := logDay(filepath.Base(f), now.Location())
+		if !ok || !day.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			log.Printf("retention: %v", err)
+			continue
+		}
+		log.Printf("retention: removed %s", f)
+	}
+}
+
+// logDay parses the date of a log named by appdir.SessionLogName.
+func logDay(name string, loc *time.Location) (time.Time, bool) {
+	date, ok := strings.CutPrefix(name, "chat-")
+	if !ok || len(date) < len("2006-01-02") {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006-01-02", date[:len("2006-01-02")], loc)
+	return t, err == nil
+}
+
+// seed registers recorded sessions so new requests can continue
+// conversations started before the daemon was (re)started, today's spending
+// still counts against budgets and cached responses stay available.
+func seed(h *handler, dir string) error {
+	sessions, err := list.LoadSessions(dir)
+	if err != nil {
+		return err
+	}
+	slices.Reverse(sessions) // oldest first
+	h.threads.Seed(sessions)
+	h.budgets.Seed(sessions)
+	// Redacted logs cannot answer requests for the original responses.
+	if h.redact == nil {
+		h.cache.Seed(sessions)
+	}
+	return nil
 }
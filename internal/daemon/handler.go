@@ -1,111 +1,472 @@
 package daemon
 
 import (
-	"bytes"
 	"encoding/json"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/promptkit/promptkit/internal/budget"
+	"github.com/promptkit/promptkit/internal/cache"
+	"github.com/promptkit/promptkit/internal/clients"
+	"github.com/promptkit/promptkit/internal/config"
+	"github.com/promptkit/promptkit/internal/fault"
+	"github.com/promptkit/promptkit/internal/metrics"
+	"github.com/promptkit/promptkit/internal/pricing"
+	"github.com/promptkit/promptkit/internal/ratelimit"
 	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/internal/redact"
+	"github.com/promptkit/promptkit/internal/retry"
+	"github.com/promptkit/promptkit/internal/route"
+	"github.com/promptkit/promptkit/internal/thread"
+	"github.com/promptkit/promptkit/internal/trace"
 	"github.com/promptkit/promptkit/pkg/session"
 )
 
+// TagsHeader carries comma-separated tags to attach to the recorded session.
+// It is not forwarded to the backend.
+const TagsHeader = "X-Promptkit-Tags"
+
+// handler proxies requests to the routed backends and records sessions for
+// supported endpoints.
+type handler struct {
+	routes  *route.Table
+	rec     *recorder.Recorder
+	threads *thread.Index
+	metrics *metrics.Metrics
+	pricing *pricing.Table
+	budgets *budget.Enforcer
+	cache   *cache.Cache
+	faults  *fault.Injector
+	limits  *ratelimit.Limiter
+	clients *clients.Map
+	retry   retry.Policy
+	redact  *redact.Redactor
+	// recordPaths are the POST endpoints whose calls are recorded.
+	recordPaths []string
+	// observers are notified of every recorded session.
+	observers []func(session.Session)
+
+	inFlight atomic.Int64 // requests being served
+	recorded atomic.Int64 // sessions written since startup
+}
+
 // newHandler returns an HTTP handler that proxies requests to the backend and
 // records sessions for supported endpoints.
-func newHandler(backend string, rec *recorder.Recorder) (http.Handler, error) {
-	base, err := url.Parse(backend)
+func newHandler(backend string, rec *recorder.Recorder) (*handler, error) {
+	routes, err := route.New(route.Config{}, backend)
 	if err != nil {
 		return nil, err
 	}
+	return &handler{routes: routes, rec: rec, threads: thread.NewIndex(), recordPaths: config.DefaultRecord}, nil
+}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	h.inFlight.Add(1)
+	defer h.inFlight.Add(-1)
 
-		bodyBytes, err := io.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
-			return
-		}
-		r.Body.Close()
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		h.metrics.ObserveAnswered(r.Method, r.URL.Path, http.StatusBadRequest, metrics.Refused)
+		return
+	}
+	r.Body.Close()
 
-		// Forward the request to the backend.
-		targetURL := base.ResolveReference(r.URL)
-		req, err := http.NewRequest(r.Method, targetURL.String(), bytes.NewReader(bodyBytes))
-		if err != nil {
-			http.Error(w, "proxy error", http.StatusInternalServerError)
-			return
+	client, err := h.clients.Identify(apiKey(r.Header))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "promptkit: "+err.Error())
+		h.metrics.ObserveAnswered(r.Method, r.URL.Path, http.StatusUnauthorized, metrics.Refused)
+		return
+	}
+	var clientName string
+	if client != nil {
+		clientName = client.Name
+	}
+
+	// Determine if this request should be recorded.
+	path := r.URL.Path
+	recorded := r.Method == http.MethodPost && slices.Contains(h.recordPaths, path)
+	tags := parseTags(r.Header.Get(TagsHeader))
+	keyHash := budget.Fingerprint(apiKey(r.Header))
+	model, estimate := ratelimit.Estimate(bodyBytes)
+
+	// Failures replacing the backend call are injected first; the others
+	// are applied to the backend's response below.
+	injected := h.faults.Pick(model, r.Header)
+	if injected != nil && !injected.Kind.Upstream() {
+		h.injectFault(w, r, bodyBytes, start, *injected, recorded, session.Metadata{Tags: tags, KeyHash: keyHash, Client: clientName})
+		return
+	}
+
+	// Serve repeated requests from the cache before they count against
+	// budgets or rate limits.
+	var cacheInfo *session.CacheInfo
+	if recorded {
+		if key := h.cache.Key(path, r.Header, bodyBytes, keyHash); key != "" {
+			cacheInfo = &session.CacheInfo{Status: session.CacheMiss, Key: key}
+			if cache.Bypass(r.Header) {
+				cacheInfo.Status = session.CacheBypass
+			} else if src, ok := h.cache.Get(key); ok && injected == nil {
+				h.serveCached(w, r, bodyBytes, start, src, session.Metadata{Tags: tags, KeyHash: keyHash, Client: clientName, Cache: cacheInfo})
+				return
+			}
 		}
-		req.Header = r.Header.Clone()
+	}
 
-		resp, err := http.DefaultClient.Do(req)
+	// Reserve the estimated spend until the request has been recorded, so
+	// concurrent requests cannot overshoot a budget together.
+	if recorded {
+		sub := budget.Subject{KeyHash: keyHash, Tags: tags}
+		reservation, err := h.budgets.Reserve(sub, estimate, h.pricing.Estimate(model, estimate))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
+			body := writeError(w, http.StatusTooManyRequests, "insufficient_quota", "budget_exceeded", err.Error())
+			h.metrics.ObserveAnswered(r.Method, r.URL.Path, http.StatusTooManyRequests, metrics.Budget)
+			h.recordRejected(r, bodyBytes, body, start, session.Metadata{Tags: tags, KeyHash: keyHash, Client: clientName})
 			return
 		}
-		defer resp.Body.Close()
-		respBody, _ := io.ReadAll(resp.Body)
+		defer reservation.Done()
+	}
 
-		for k, vv := range resp.Header {
-			for _, v := range vv {
-				w.Header().Add(k, v)
-			}
-		}
-		w.WriteHeader(resp.StatusCode)
-		_, _ = w.Write(respBody)
+	backends := h.routes.Resolve(model, path)
+	if len(backends) == 0 {
+		writeError(w, http.StatusBadGateway, "invalid_request_error", "no_backend", "no backend configured for this request")
+		h.metrics.ObserveAnswered(r.Method, r.URL.Path, http.StatusBadGateway, metrics.Refused)
+		return
+	}
+
+	header := r.Header.Clone()
+	header.Del(TagsHeader)
+	header.Del(cache.Header)
+	header.Del(fault.Header)
+	if client != nil && client.UpstreamKey != "" {
+		setAPIKey(header, client.UpstreamKey)
+	}
+
+	// Give the proxied call its own span so agents can reconstruct their
+	// call graph, and pass it on as the parent of any upstream spans.
+	tc, traced := trace.Parse(r.Header.Get(trace.Header))
+	parentSpan := tc.SpanID
+	if traced {
+		tc.SpanID = trace.NewSpanID()
+		header.Set(trace.Header, tc.String())
+	}
 
-		// Determine if this request should be recorded.
-		path := r.URL.Path
-		if r.Method != http.MethodPost || (path != "/v1/completions" && path != "/v1/chat/completions") {
+	// Forward the request, falling back to the next backend of the route
+	// while the previous one fails. Each backend is called once the request
+	// fits its rate limits; the wait is recorded separately, so latency only
+	// covers the upstream calls.
+	upstreamStart := time.Now()
+	safe := retry.Safe(r.Method, path, r.Header)
+	var (
+		resp      *http.Response
+		respBody  []byte
+		attempts  []session.Attempt
+		backend   *route.Backend
+		ticket    *ratelimit.Ticket
+		queueWait time.Duration
+	)
+	used := estimate
+	defer func() { ticket.Done(used) }()
+	for i, b := range backends {
+		next, aerr := h.limits.Acquire(r.Context(), b.Name, model, estimate)
+		if aerr != nil && i == 0 {
+			wait := time.Since(start)
+			body := writeError(w, http.StatusTooManyRequests, "requests", "rate_limit_exceeded", aerr.Error())
+			h.metrics.ObserveAnswered(r.Method, r.URL.Path, http.StatusTooManyRequests, metrics.RateLimited)
+			if recorded {
+				h.recordRejected(r, bodyBytes, body, start, session.Metadata{Tags: tags, KeyHash: keyHash, Client: clientName, QueueWaitMS: wait.Milliseconds()})
+			}
 			return
 		}
+		if aerr != nil {
+			log.Printf("backend %s: %v, keeping the response of %s", b.Name, aerr, backend.Name)
+			break
+		}
+		ticket.Done(estimate)
+		ticket = next
+		if ticket != nil {
+			queueWait += ticket.Wait
+		}
 
-		var reqPayload map[string]any
-		if err := json.Unmarshal(bodyBytes, &reqPayload); err != nil {
-			return // malformed payload
+		hdr := b.Header(header)
+		var tries []session.Attempt
+		resp, respBody, tries, err = h.roundTrip(r.Context(), r.Method, b.Target(r.URL), hdr, bodyBytes, safe)
+		for j := range tries {
+			tries[j].Backend = b.Name
 		}
-		var respPayload any
-		if err := json.Unmarshal(respBody, &respPayload); err != nil {
-			respPayload = string(respBody)
+		attempts = append(attempts, tries...)
+		backend = b
+		failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !failed || !safe || i == len(backends)-1 {
+			break
 		}
+		log.Printf("backend %s failed, falling back to %s", b.Name, backends[i+1].Name)
+	}
+	if err != nil {
+		h.metrics.ObserveRequest(r.Method, r.URL.Path, 0, 0, len(bodyBytes), 0)
+		used = 0
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		if recorded {
+			md := session.Metadata{Tags: tags, KeyHash: keyHash, Client: clientName, QueueWaitMS: queueWait.Milliseconds(), Backend: backend.Name, Attempts: attempts}
+			if traced {
+				md.TraceID, md.SpanID, md.ParentSpanID = tc.TraceID, tc.SpanID, parentSpan
+			}
+			h.recordFailed(r, bodyBytes, []byte(err.Error()), start.Add(queueWait), md)
+		}
+		return
+	}
+	if len(attempts) < 2 {
+		attempts = nil
+	}
+	h.metrics.ObserveRequest(r.Method, r.URL.Path, resp.StatusCode, time.Since(upstreamStart), len(bodyBytes), len(respBody))
 
-		stream := false
-		if v, ok := reqPayload["stream"].(bool); ok && v {
-			stream = true
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	clientBody := respBody
+	if injected != nil {
+		cacheInfo = nil // never cache a broken response
+		switch injected.Kind {
+		case fault.SlowFirstToken:
+			select {
+			case <-time.After(injected.DelayOrDefault()):
+			case <-r.Context().Done():
+			}
+		case fault.Truncate:
+			// Keep the Content-Length and drop the connection after the
+			// partial body, so the client sees the response end early.
+			clientBody = fault.Truncated(respBody)
+			defer panic(http.ErrAbortHandler)
+		case fault.MalformedJSON:
+			clientBody = fault.Malformed(respBody)
+			w.Header().Del("Content-Length")
 		}
+	}
+	if cacheInfo != nil {
+		w.Header().Set(cache.Header, string(cacheInfo.Status))
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(clientBody)
+
+	if !recorded {
+		return
+	}
+
+	sess, err := session.FromExchange(r.Method, path, bodyBytes, resp.StatusCode, clientBody, start.Add(queueWait))
+	if err != nil {
+		return // malformed payload
+	}
+	sess.Metadata.Tags = tags
+	sess.Metadata.KeyHash = keyHash
+	sess.Metadata.Client = clientName
+	sess.Metadata.QueueWaitMS = queueWait.Milliseconds()
+	sess.Metadata.Backend = backend.Name
+	sess.Metadata.Attempts = attempts
+	sess.Metadata.Cache = cacheInfo
+	if injected != nil {
+		sess.Metadata.Fault = string(injected.Kind)
+	}
+	if traced {
+		sess.Metadata.TraceID = tc.TraceID
+		sess.Metadata.SpanID = tc.SpanID
+		sess.Metadata.ParentSpanID = parentSpan
+	}
+	// Redaction edits the recorded session in place, so the cache keeps its
+	// own copy of the response as the client received it.
+	cached := sess
+	if h.redact != nil && cacheInfo != nil {
+		cached = cloneSession(sess)
+	}
+	h.record(&sess)
+	if u := sess.Metadata.Usage; u != nil {
+		used = u.PromptTokens + u.CompletionTokens
+	}
+	h.cache.Put(cached)
+}
+
+// cloneSession returns a deep copy of s.
+func cloneSession(s session.Session) session.Session {
+	var out session.Session
+	b, err := json.Marshal(s)
+	if err != nil || json.Unmarshal(b, &out) != nil {
+		return s
+	}
+	return out
+}
+
+// record accounts the cost of sess, redacts it, links it to its conversation
+// thread, hashes it and writes it.
+func (h *handler) record(sess *session.Session) {
+	if sess.Metadata.Status != session.StatusRejected {
+		h.pricing.Account(sess)
+	}
+	if c := sess.Metadata.Cache; c != nil && c.Status == session.CacheHit {
+		sess.Metadata.CostUSD = 0
+	}
+	h.redact.Session(sess)
+	// Threads are matched on the messages as logged, which is also what the
+	// index is seeded from on restart.
+	h.threads.Assign(sess)
+
+	hash, err := session.ComputeHash(*sess)
+	if err == nil {
+		sess.Metadata.SessionHash = hash
+	}
+
+	if err := h.rec.Record(sess); err != nil {
+		h.metrics.RecordFailed()
+		log.Printf("record: %v", err)
+	} else {
+		h.recorded.Add(1)
+	}
+	h.budgets.AddSession(*sess)
+	h.metrics.ObserveSession(*sess)
+	for _, observe := range h.observers {
+		observe(*sess)
+	}
+}
+
+// serveCached answers a request with the response of the recorded session
+// src and records the hit. md carries the request metadata known so far.
+func (h *handler) serveCached(w http.ResponseWriter, r *http.Request, reqBody []byte, start time.Time, src session.Session, md session.Metadata) {
+	contentType, body := cache.Body(src)
+	status := src.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set(cache.Header, string(session.CacheHit))
+	w.WriteHeader(status)
+	w.Write(body)
+	h.metrics.ObserveAnswered(r.Method, r.URL.Path, status, metrics.CacheHit)
+
+	sess, err := session.FromExchange(r.Method, r.URL.Path, reqBody, status, body, start)
+	if err != nil {
+		return // malformed payload
+	}
+	sess.Metadata.Tags = md.Tags
+	sess.Metadata.KeyHash = md.KeyHash
+	sess.Metadata.Client = md.Client
+	sess.Metadata.Cache = &session.CacheInfo{Status: session.CacheHit, Key: md.Cache.Key, SourceID: src.ID}
+	h.record(&sess)
+}
 
-		sess := session.Session{
-			ID:           time.Now().Format("20060102150405"),
-			Origin:       session.OriginProxy,
-			SourcePrompt: "",
-			Request: session.OpenAIRequest{
-				// Legacy proxy format for backward compatibility
-				Method:  r.Method,
-				Path:    path,
-				Payload: reqPayload,
-				Stream:  stream,
-			},
-			Response: session.OpenAIResponse{
-				// Legacy proxy format for backward compatibility
-				Status: resp.StatusCode,
-				Body:   respPayload,
-			},
-			Stream: stream,
-			Metadata: session.Metadata{
-				Timestamp: time.Now(),
-				LatencyMS: time.Since(start).Milliseconds(),
-			},
+// injectFault answers a request with an injected failure instead of calling
+// the backend, and records it. md carries the request metadata known so far.
+func (h *handler) injectFault(w http.ResponseWriter, r *http.Request, reqBody []byte, start time.Time, f fault.Rule, recorded bool, md session.Metadata) {
+	var status int
+	var body []byte
+	switch f.Kind {
+	case fault.RateLimit:
+		status = http.StatusTooManyRequests
+		body = writeError(w, status, "requests", "rate_limit_exceeded", "injected fault: rate limit reached")
+	case fault.ServerError:
+		status = http.StatusInternalServerError
+		body = writeError(w, status, "server_error", "server_error", "injected fault: the server had an error")
+	case fault.Timeout:
+		status = http.StatusGatewayTimeout
+		select {
+		case <-time.After(f.DelayOrDefault()):
+		case <-r.Context().Done():
 		}
+	}
+	h.metrics.ObserveAnswered(r.Method, r.URL.Path, status, metrics.Fault)
 
-		hash, err := session.ComputeHash(sess)
-		if err == nil {
-			sess.Metadata.SessionHash = hash
+	if recorded {
+		if sess, err := session.FromExchange(r.Method, r.URL.Path, reqBody, status, body, start); err == nil {
+			sess.Metadata.Tags = md.Tags
+			sess.Metadata.KeyHash = md.KeyHash
+			sess.Metadata.Client = md.Client
+			sess.Metadata.Fault = string(f.Kind)
+			h.record(&sess)
 		}
+	}
+	if f.Kind == fault.Timeout {
+		panic(http.ErrAbortHandler) // drop the connection without a response
+	}
+}
 
-		if err := rec.Record(&sess); err != nil {
-			log.Printf("record: %v", err)
+// recordRejected records a request the daemon answered itself without
+// forwarding it. md carries the request metadata known so far.
+func (h *handler) recordRejected(r *http.Request, reqBody, respBody []byte, start time.Time, md session.Metadata) {
+	sess, err := session.FromExchange(r.Method, r.URL.Path, reqBody, http.StatusTooManyRequests, respBody, start)
+	if err != nil {
+		return // malformed payload
+	}
+	sess.Metadata.Tags = md.Tags
+	sess.Metadata.KeyHash = md.KeyHash
+	sess.Metadata.Client = md.Client
+	sess.Metadata.QueueWaitMS = md.QueueWaitMS
+	sess.Metadata.Status = session.StatusRejected
+	h.record(&sess)
+}
+
+// recordFailed records a request no backend answered, with a 502 status and
+// the failed attempts. md carries the request metadata known so far.
+func (h *handler) recordFailed(r *http.Request, reqBody, respBody []byte, start time.Time, md session.Metadata) {
+	sess, err := session.FromExchange(r.Method, r.URL.Path, reqBody, http.StatusBadGateway, respBody, start)
+	if err != nil {
+		return // malformed payload
+	}
+	sess.Metadata.Tags = md.Tags
+	sess.Metadata.KeyHash = md.KeyHash
+	sess.Metadata.Client = md.Client
+	sess.Metadata.QueueWaitMS = md.QueueWaitMS
+	sess.Metadata.Backend = md.Backend
+	sess.Metadata.Attempts = md.Attempts
+	sess.Metadata.TraceID = md.TraceID
+	sess.Metadata.SpanID = md.SpanID
+	sess.Metadata.ParentSpanID = md.ParentSpanID
+	h.record(&sess)
+}
+
+// writeError responds with an OpenAI-compatible error and returns the body.
+func writeError(w http.ResponseWriter, status int, typ, code, msg string) []byte {
+	body, _ := json.Marshal(map[string]any{"error": map[string]any{
+		"message": msg,
+		"type":    typ,
+		"param":   nil,
+		"code":    code,
+	}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+	return body
+}
+
+// apiKey returns the API key a request was made with, from either an OpenAI
+// bearer token or an Azure api-key header.
+func apiKey(h http.Header) string {
+	if key, ok := strings.CutPrefix(h.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(key)
+	}
+	return h.Get("Api-Key")
+}
+
+// setAPIKey replaces the API key of a request, keeping the header the client
+// sent it in.
+func setAPIKey(h http.Header, key string) {
+	if h.Get("Authorization") == "" && h.Get("Api-Key") != "" {
+		h.Set("Api-Key", key)
+		return
+	}
+	h.Set("Authorization", "Bearer "+key)
+}
+
+func parseTags(v string) []string {
+	var tags []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
 		}
-	}), nil
+	}
+	return tags
 }
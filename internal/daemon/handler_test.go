@@ -7,13 +7,60 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/promptkit/promptkit/internal/budget"
+	"github.com/promptkit/promptkit/internal/cache"
+	"github.com/promptkit/promptkit/internal/clients"
+	"github.com/promptkit/promptkit/internal/fault"
+	"github.com/promptkit/promptkit/internal/metrics"
+	"github.com/promptkit/promptkit/internal/ratelimit"
 	"github.com/promptkit/promptkit/internal/recorder"
+	"github.com/promptkit/promptkit/internal/redact"
+	"github.com/promptkit/promptkit/internal/retry"
+	"github.com/promptkit/promptkit/internal/route"
 	"github.com/promptkit/promptkit/pkg/session"
 )
 
+func TestThreadRedaction(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"noted"}}]}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	post := func(h *handler, body string) {
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	h, _ := newHandler(backend.URL, rec)
+	h.redact, _ = redact.New([]string{`sk-[a-z0-9]+`})
+	post(h, `{"model":"gpt-4o","messages":[{"role":"user","content":"my key is sk-abc"}]}`)
+
+	// A restarted daemon seeds its threads from the redacted log.
+	restarted, _ := newHandler(backend.URL, rec)
+	restarted.redact = h.redact
+	restarted.threads.Seed(readSessions(t, tmp.Name()))
+	post(restarted, `{"model":"gpt-4o","messages":[{"role":"user","content":"my key is sk-abc"},{"role":"assistant","content":"noted"},{"role":"user","content":"thanks"}]}`)
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 2 || sess[1].Metadata.ThreadID != sess[0].Metadata.ThreadID || sess[1].Metadata.Turn != 2 {
+		t.Fatalf("follow-up not threaded after restart: %+v, %+v", sess[0].Metadata, sess[1].Metadata)
+	}
+}
+
 func readSessions(t *testing.T, path string) []session.Session {
 	t.Helper()
 	f, err := os.Open(path)
@@ -108,6 +155,63 @@ func TestIgnoreOtherEndpoints(t *testing.T) {
 	}
 }
 
+func TestRecordPathsAndRedaction(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":[{"embedding":[0.1]}],"echo":"sk-secret1"}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.recordPaths = []string{"/v1/embeddings"}
+	h.redact, _ = redact.New([]string{`sk-[a-z0-9]+`})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/embeddings", "application/json", strings.NewReader(`{"model":"emb","input":"key sk-abc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "sk-secret1") {
+		t.Errorf("client response was redacted: %s", body)
+	}
+	http.Post(srv.URL+"/v1/completions", "application/json", strings.NewReader(`{"model":"gpt","prompt":"hi"}`))
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 1 || sess[0].Request.Path != "/v1/embeddings" {
+		t.Fatalf("sessions = %+v, want only the embeddings call", sess)
+	}
+	b, _ := json.Marshal(sess[0])
+	if strings.Contains(string(b), "sk-") || !strings.Contains(string(b), "key [REDACTED]") {
+		t.Errorf("recorded session not redacted: %s", b)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 7, 10, 12, 0, 0, 0, time.Local)
+	for _, name := range []string{"chat-2025-07-01.jsonl.gz", "chat-2025-07-02.jsonl", "chat-2025-07-03.jsonl", "chat-2025-07-10.jsonl"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+	}
+	prune(dir, 7, now)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if want := "chat-2025-07-03.jsonl chat-2025-07-10.jsonl"; strings.Join(names, " ") != want {
+		t.Errorf("kept %v, want %s", names, want)
+	}
+}
+
 func TestStreamFlag(t *testing.T) {
 	// Backend that sends simple SSE stream
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -136,3 +240,510 @@ func TestStreamFlag(t *testing.T) {
 		t.Fatalf("expected stream flag true")
 	}
 }
+
+func TestTraceContext(t *testing.T) {
+	var upstream string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("traceparent")
+		io.WriteString(w, `{}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt"}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sess))
+	}
+	md := sess[0].Metadata
+	if md.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || md.ParentSpanID != "00f067aa0ba902b7" || md.SpanID == "" {
+		t.Fatalf("unexpected trace metadata %+v", md)
+	}
+	if want := "00-" + md.TraceID + "-" + md.SpanID + "-01"; upstream != want {
+		t.Fatalf("upstream traceparent %q, want %q", upstream, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.metrics = metrics.New()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	admin := httptest.NewServer(newAdminRouter(h.metrics, nil, nil))
+	defer admin.Close()
+
+	http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt"}`))
+	http.Get(srv.URL + "/v1/models")
+
+	resp, err := http.Get(admin.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="forwarded",status="200"} 1`,
+		`promptkit_upstream_errors_total{code="503",endpoint="/v1/models"} 1`,
+		`promptkit_tokens_total{model="gpt",type="completion"} 2`,
+		`promptkit_upstream_latency_seconds_count{endpoint="/v1/chat/completions"} 1`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestBudgetRejection(t *testing.T) {
+	var calls int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get(TagsHeader) != "" {
+			t.Errorf("tags header forwarded upstream")
+		}
+		io.WriteString(w, `{"usage":{"prompt_tokens":60,"completion_tokens":40,"total_tokens":100}}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.budgets, _ = budget.New([]budget.Limit{{Scope: budget.ScopeTag, Match: "batch", MaxTokens: 100}})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func() *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt"}`))
+		req.Header.Set(TagsHeader, "batch, nightly")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := post(); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first request status %d", resp.StatusCode)
+	}
+	if resp := post(); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second request status %d, want 429", resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Fatalf("backend called %d times, want 1", calls)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sess))
+	}
+	if got := sess[0].Metadata.Tags; len(got) != 2 || got[0] != "batch" || got[1] != "nightly" {
+		t.Errorf("tags = %v", got)
+	}
+	rejected := sess[1]
+	if rejected.Metadata.Status != session.StatusRejected || rejected.Response.Status != http.StatusTooManyRequests {
+		t.Fatalf("rejected session = %+v", rejected)
+	}
+	body, _ := rejected.Response.Body.(map[string]any)
+	errObj, _ := body["error"].(map[string]any)
+	if errObj["code"] != "budget_exceeded" {
+		t.Errorf("error body = %v", body)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls != 2 {
+		t.Fatalf("status %d after %d calls, want 200 after 2", resp.StatusCode, calls)
+	}
+	sess := readSessions(t, tmp.Name())
+	attempts := sess[0].Metadata.Attempts
+	if len(attempts) != 2 || attempts[0].Status != http.StatusServiceUnavailable || attempts[1].Status != http.StatusOK {
+		t.Fatalf("attempts = %+v", attempts)
+	}
+
+	// Requests with side effects are passed through after one attempt.
+	calls = 0
+	resp, err = http.Post(srv.URL+"/v1/files", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Fatalf("unsafe request: status %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var primaryAuth, secondaryAuth string
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	var secondaryPath string
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryPath = r.URL.Path
+		secondaryAuth = r.Header.Get("Authorization")
+		io.WriteString(w, `{}`)
+	}))
+	defer secondary.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(primary.URL, rec)
+	h.routes, _ = route.New(route.Config{
+		Backends: map[string]*route.Backend{"vllm": {URL: secondary.URL + "/proxy"}},
+		Routes:   []route.Rule{{Model: "llama*", Backends: []string{route.Default, "vllm"}}},
+	}, primary.URL)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"llama-3"}`))
+	req.Header.Set("Authorization", "Bearer sk-openai")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || secondaryPath != "/proxy/v1/chat/completions" {
+		t.Fatalf("status %d, secondary path %q", resp.StatusCode, secondaryPath)
+	}
+	if primaryAuth != "Bearer sk-openai" || secondaryAuth != "" {
+		t.Fatalf("default backend got %q, fallback got %q", primaryAuth, secondaryAuth)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	md := sess[0].Metadata
+	if md.Backend != "vllm" || len(md.Attempts) != 2 || md.Attempts[0].Backend != route.Default || md.Attempts[0].Status != http.StatusInternalServerError {
+		t.Fatalf("backend %q, attempts %+v", md.Backend, md.Attempts)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(down.URL, rec)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt-4o"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 1 {
+		t.Fatalf("recorded %d sessions", len(sess))
+	}
+	md := sess[0].Metadata
+	if sess[0].Response.Status != http.StatusBadGateway || md.Backend != route.Default || len(md.Attempts) == 0 || md.Attempts[0].Status != 0 {
+		t.Fatalf("status %d, backend %q, attempts %+v", sess[0].Response.Status, md.Backend, md.Attempts)
+	}
+}
+
+func TestFallbackRateLimit(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+	var secondaryCalls int
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls++
+		io.WriteString(w, `{}`)
+	}))
+	defer secondary.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(primary.URL, rec)
+	h.routes, _ = route.New(route.Config{
+		Backends: map[string]*route.Backend{"vllm": {URL: secondary.URL}},
+		Routes:   []route.Rule{{Backends: []string{route.Default, "vllm"}}},
+	}, primary.URL)
+	h.limits = ratelimit.New(ratelimit.Config{
+		WaitTimeout: 50 * time.Millisecond,
+		Backends:    map[string]ratelimit.Rule{"vllm": {RPM: 1}},
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for i, want := range []int{http.StatusOK, http.StatusInternalServerError} {
+		resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"llama-3"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, resp.StatusCode, want)
+		}
+	}
+	if secondaryCalls != 1 {
+		t.Fatalf("fallback called %d times despite its rate limit", secondaryCalls)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.cache = cache.New(cache.Config{})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(cacheControl string) string {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt","temperature":0}`))
+		if cacheControl != "" {
+			req.Header.Set("Cache-Control", cacheControl)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if !strings.Contains(string(b), `"hi"`) {
+			t.Errorf("unexpected body %s", b)
+		}
+		return resp.Header.Get(cache.Header)
+	}
+	for i, want := range []string{"miss", "hit", "bypass"} {
+		cc := ""
+		if want == "bypass" {
+			cc = "no-cache"
+		}
+		if got := post(cc); got != want {
+			t.Errorf("request %d: cache %q, want %q", i+1, got, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("backend called %d times, want 2", calls)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sess))
+	}
+	hit := sess[1].Metadata.Cache
+	if hit == nil || hit.Status != session.CacheHit || hit.SourceID != sess[0].ID || hit.Key != sess[0].Metadata.Cache.Key {
+		t.Fatalf("hit metadata = %+v", hit)
+	}
+}
+
+func TestCacheRedaction(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"choices":[{"message":{"content":"use sk-live9"}}]}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.cache = cache.New(cache.Config{})
+	h.redact, _ = redact.New([]string{`sk-[a-z0-9]+`})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	for i, want := range []string{"miss", "hit"} {
+		resp, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gpt","temperature":0}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := resp.Header.Get(cache.Header); got != want {
+			t.Fatalf("request %d: cache %q, want %q", i+1, got, want)
+		}
+		if !strings.Contains(string(b), "sk-live9") {
+			t.Fatalf("request %d: client got a redacted response: %s", i+1, b)
+		}
+	}
+	for _, s := range readSessions(t, tmp.Name()) {
+		if b, _ := json.Marshal(s); strings.Contains(string(b), "sk-live9") {
+			t.Fatalf("recorded session not redacted: %s", b)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	var calls int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, `{"choices":[{"message":{"content":"hi"}}]}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+
+	h, _ := newHandler(backend.URL, rec)
+	h.faults, _ = fault.New(nil)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(kind fault.Kind) (*http.Response, []byte, error) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(`{"model":"gpt"}`))
+		req.Header.Set(fault.Header, string(kind))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return resp, b, err
+	}
+
+	resp, _, err := post(fault.RateLimit)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests || calls != 0 {
+		t.Fatalf("rate_limit: %v, %v, %d backend calls", resp, err, calls)
+	}
+	resp, body, err := post(fault.MalformedJSON)
+	if err != nil || resp.StatusCode != http.StatusOK || json.Valid(body) {
+		t.Fatalf("malformed_json: %v, %q, %v", resp, body, err)
+	}
+	if _, _, err := post(fault.Truncate); err == nil {
+		t.Fatal("truncate: response read without error")
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 3 {
+		t.Fatalf("expected 3 sessions, got %d", len(sess))
+	}
+	for i, want := range []fault.Kind{fault.RateLimit, fault.MalformedJSON, fault.Truncate} {
+		if sess[i].Metadata.Fault != string(want) {
+			t.Errorf("session %d fault %q, want %q", i, sess[i].Metadata.Fault, want)
+		}
+	}
+}
+
+func TestClientKeys(t *testing.T) {
+	var gotKey string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"1"}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+	h, _ := newHandler(backend.URL, rec)
+	h.clients, _ = clients.New(clients.Config{Clients: []clients.Client{
+		{Name: "notebook", Key: "pk-notebook", UpstreamKey: "sk-real"},
+	}})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	post := func(key string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/completions", strings.NewReader(`{"model":"gpt","prompt":"hi"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("pk-notebook"); status != http.StatusOK {
+		t.Fatalf("known client status = %d", status)
+	}
+	if gotKey != "Bearer sk-real" {
+		t.Errorf("backend got Authorization %q, want the upstream key", gotKey)
+	}
+	if status := post("sk-stolen"); status != http.StatusUnauthorized {
+		t.Errorf("unknown key status = %d, want 401", status)
+	}
+
+	sess := readSessions(t, tmp.Name())
+	if len(sess) != 1 || sess[0].Metadata.Client != "notebook" || sess[0].Metadata.KeyHash != budget.Fingerprint("pk-notebook") {
+		t.Errorf("sessions = %+v", sess)
+	}
+}
@@ -0,0 +1,273 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/auth"
+	"github.com/promptkit/promptkit/pkg/version"
+)
+
+// DefaultShutdownTimeout bounds how long a stopping daemon waits for
+// in-flight requests.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// State describes a running daemon. It is stored in the PID file for as long
+// as the daemon runs.
+type State struct {
+	PID       int    `json:"pid"`
+	Addr      string `json:"addr"`
+	AdminAddr string `json:"admin_addr,omitempty"`
+	// Control is set when the admin address also serves the control API.
+	Control bool `json:"control,omitempty"`
+	// TLS is set when both listeners serve HTTPS.
+	TLS       bool      `json:"tls,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// AdminURL returns the base URL of the admin endpoint, or "" if the daemon
+// has none.
+func (s *State) AdminURL() string {
+	if s.AdminAddr == "" {
+		return ""
+	}
+	return auth.BaseURL(s.AdminAddr, s.TLS)
+}
+
+// ControlURL returns the base URL of the control API, or "" if the daemon
+// does not serve it.
+func (s *State) ControlURL() string {
+	if !s.Control {
+		return ""
+	}
+	return s.AdminURL()
+}
+
+// Status is reported by the admin /status endpoint. Its status and version
+// fields match the control API's /status.
+type Status struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	State
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	InFlight      int64   `json:"in_flight"`
+	Recorded      int64   `json:"recorded"`
+	SessionLog    string  `json:"session_log"`
+}
+
+// ReadState returns the state of the daemon recorded in the PID file, or nil
+// if there is none.
+func ReadState() (*State, error) {
+	path, err := appdir.DaemonPIDPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// Alive reports whether the daemon process still exists.
+func (s *State) Alive() bool {
+	return processAlive(s.PID)
+}
+
+// RemoveState deletes the PID file, e.g. one left behind by a daemon that
+// was killed.
+func RemoveState() error {
+	path, err := appdir.DaemonPIDPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeState creates the PID file, failing if another live daemon owns it.
+func writeState(st State) error {
+	if cur, err := ReadState(); err == nil && cur != nil && cur.PID != st.PID && cur.Alive() {
+		return fmt.Errorf("daemon already running (pid %d)", cur.PID)
+	}
+	path, err := appdir.DaemonPIDPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(st)
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// removeOwnState deletes the PID file if it still belongs to pid.
+func removeOwnState(pid int) {
+	if cur, err := ReadState(); err == nil && cur != nil && cur.PID == pid {
+		RemoveState()
+	}
+}
+
+// lifecycle serves the status and shutdown admin endpoints of a running
+// daemon.
+type lifecycle struct {
+	state   State
+	h       *handler
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newLifecycle(st State, h *handler) *lifecycle {
+	return &lifecycle{state: st, h: h, stopped: make(chan struct{})}
+}
+
+func (l *lifecycle) status() Status {
+	return Status{
+		Status:        "ok",
+		Version:       version.Version,
+		State:         l.state,
+		UptimeSeconds: time.Since(l.state.StartedAt).Seconds(),
+		InFlight:      l.h.inFlight.Load(),
+		Recorded:      l.h.recorded.Load(),
+		SessionLog:    l.h.rec.Path(),
+	}
+}
+
+// shutdown asks the daemon to stop. It is safe to call more than once.
+func (l *lifecycle) shutdown() {
+	l.once.Do(func() { close(l.stopped) })
+}
+
+func (l *lifecycle) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.status())
+}
+
+func (l *lifecycle) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusAccepted)
+	l.shutdown()
+}
+
+// FetchStatus queries the admin endpoint of a running daemon. client carries
+// the token and TLS settings the endpoint requires; nil uses
+// http.DefaultClient.
+func FetchStatus(ctx context.Context, client *http.Client, st *State) (*Status, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, st.AdminURL()+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %s", resp.Status)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stop asks the daemon to shut down gracefully, through its admin endpoint
+// if it has one and with SIGTERM otherwise, and waits up to timeout for the
+// process to exit. client is used as for FetchStatus.
+func Stop(ctx context.Context, client *http.Client, st *State, timeout time.Duration) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	requested := false
+	if st.AdminAddr != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, st.AdminURL()+"/shutdown", nil)
+		if err == nil {
+			if resp, err := client.Do(req); err == nil {
+				resp.Body.Close()
+				requested = resp.StatusCode == http.StatusAccepted
+			}
+		}
+	}
+	if !requested {
+		if err := terminate(st.PID); err != nil {
+			return fmt.Errorf("signal pid %d: %w", st.PID, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for st.Alive() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("daemon (pid %d) did not stop within %s", st.PID, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Detach starts the daemon in the background by re-running the executable
+// with args, appending its output to logPath. It waits until the daemon has
+// written its PID file, or returns an error if it exits first.
+func Detach(args []string, logPath string, timeout time.Duration) (*State, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachAttr()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("daemon exited during startup (%v); see %s", err, logPath)
+		case <-deadline:
+			return nil, fmt.Errorf("daemon (pid %d) did not start within %s; see %s", cmd.Process.Pid, timeout, logPath)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if st, err := ReadState(); err == nil && st != nil && st.PID == cmd.Process.Pid {
+			return st, nil
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/metrics"
+	"github.com/promptkit/promptkit/internal/recorder"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"1"}`)
+	}))
+	defer backend.Close()
+
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+	h, _ := newHandler(backend.URL, rec)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(ln)
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/v1/completions", "application/json", strings.NewReader(`{"model":"gpt","prompt":"hi"}`))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	for h.inFlight.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- shutdown(srv, h, 5*time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("new connections accepted while draining")
+	}
+	close(release)
+
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", status)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if sess := readSessions(t, tmp.Name()); len(sess) != 1 {
+		t.Errorf("recorded %d sessions, want 1", len(sess))
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Setenv("KITOPS_HOME", t.TempDir())
+	tmp, _ := os.CreateTemp(t.TempDir(), "log")
+	tmp.Close()
+	rec, _ := recorder.New(tmp.Name())
+	defer rec.Close()
+	h, _ := newHandler("http://127.0.0.1:1", rec)
+
+	st := State{PID: os.Getpid(), Addr: ":8080", AdminAddr: "127.0.0.1:8081", StartedAt: time.Now()}
+	if err := writeState(st); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadState()
+	if err != nil || got == nil || got.PID != st.PID || !got.Alive() {
+		t.Fatalf("ReadState() = %+v, %v", got, err)
+	}
+	if err := writeState(State{PID: st.PID + 1}); err == nil {
+		t.Error("second daemon allowed to take over the PID file")
+	}
+
+	lc := newLifecycle(st, h)
+	admin := httptest.NewServer(newAdminRouter(metrics.New(), lc, nil))
+	defer admin.Close()
+
+	status, err := FetchStatus(t.Context(), nil, &State{AdminAddr: strings.TrimPrefix(admin.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PID != st.PID || status.SessionLog != tmp.Name() {
+		t.Errorf("status = %+v", status)
+	}
+	b, _ := json.Marshal(status)
+	if !strings.Contains(string(b), `"in_flight":0`) {
+		t.Errorf("status JSON = %s", b)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, admin.URL+"/shutdown", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin shutdown: status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(admin.URL+"/shutdown", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-lc.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown endpoint did not stop the daemon")
+	}
+
+	removeOwnState(st.PID)
+	if got, _ := ReadState(); got != nil {
+		t.Errorf("PID file left behind: %+v", got)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	b := []byte("a\nb\nc\n")
+	if got := string(lastLines(b, 2)); got != "b\nc\n" {
+		t.Errorf("lastLines(2) = %q", got)
+	}
+	if got := string(lastLines(b, 5)); got != "a\nb\nc\n" {
+		t.Errorf("lastLines(5) = %q", got)
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	t.Setenv("KITOPS_HOME", t.TempDir())
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"1"}`)
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	rec, _ := recorder.New(filepath.Join(dir, "chat-2025-07-01.jsonl"))
+	defer rec.Close()
+	h, _ := newHandler(backend.URL, rec)
+	ctl, err := startControl("", dir, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.observers = append(h.observers, ctl.Publish)
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+	lc := newLifecycle(State{PID: os.Getpid(), Control: true}, h)
+	admin := httptest.NewServer(newAdminRouter(metrics.New(), lc, ctl.Handler()))
+	defer admin.Close()
+
+	events, err := http.Get(admin.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+	got := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(events.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				got <- data
+				return
+			}
+		}
+	}()
+
+	resp, err := http.Post(proxy.URL+"/v1/completions", "application/json", strings.NewReader(`{"model":"gpt","prompt":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case data := <-got:
+		if !strings.Contains(data, `"model":"gpt"`) {
+			t.Errorf("event = %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no session event")
+	}
+
+	for path, want := range map[string]string{
+		"/status":   `"control":true`,
+		"/sessions": `"model":"gpt"`,
+		"/metrics":  "promptkit_",
+	} {
+		resp, err := http.Get(admin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(b), want) {
+			t.Errorf("GET %s = %s, want %s", path, b, want)
+		}
+	}
+
+	// A web page cannot import sessions with a form-like POST.
+	req, _ := http.NewRequest(http.MethodPost, admin.URL+"/sessions", strings.NewReader(`{"request":{"prompt":"hi"}}`))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin import = %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// TailLog writes the last lines lines of the log at path to w, or all of it
+// if lines is zero. With follow it keeps writing appended output until ctx is
+// done, starting over if the log is truncated.
+func TailLog(ctx context.Context, w io.Writer, path string, lines int, follow bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	w.Write(lastLines(b, lines))
+	if !follow {
+		return nil
+	}
+
+	offset := int64(len(b))
+	buf := make([]byte, 32<<10)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(250 * time.Millisecond):
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			offset = 0 // truncated
+		}
+		for {
+			n, err := f.ReadAt(buf, offset)
+			w.Write(buf[:n])
+			offset += int64(n)
+			if err != nil || n < len(buf) {
+				break
+			}
+		}
+	}
+}
+
+// lastLines returns the last n lines of b, or b if n is zero.
+func lastLines(b []byte, n int) []byte {
+	if n <= 0 {
+		return b
+	}
+	end := len(b)
+	if end > 0 && b[end-1] == '\n' {
+		end--
+	}
+	for i := 0; i < n; i++ {
+		j := bytes.LastIndexByte(b[:end], '\n')
+		if j < 0 {
+			return b
+		}
+		end = j
+	}
+	return b[end+1:]
+}
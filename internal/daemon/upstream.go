@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/retry"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// roundTrip sends a request to a backend, retrying failures per h.retry
+// when safe is set. The returned response body has already been read and
+// closed. Every attempt is returned so flaky backends show up in the
+// recorded session.
+func (h *handler) roundTrip(ctx context.Context, method, target string, header http.Header, reqBody []byte, safe bool) (*http.Response, []byte, []session.Attempt, error) {
+	var attempts []session.Attempt
+	for n := 1; ; n++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, nil, attempts, err
+		}
+		req.Header = header
+
+		t0 := time.Now()
+		resp, body, err := do(req)
+		a := session.Attempt{LatencyMS: time.Since(t0).Milliseconds()}
+		var respHeader http.Header
+		if err != nil {
+			a.Error = err.Error()
+		} else {
+			a.Status = resp.StatusCode
+			respHeader = resp.Header
+		}
+		if n >= h.retry.MaxAttempts || !safe || !retry.Retryable(a.Status) {
+			return resp, body, append(attempts, a), err
+		}
+		delay, ok := h.retry.Delay(n+1, respHeader)
+		if !ok {
+			return resp, body, append(attempts, a), err
+		}
+		a.BackoffMS = delay.Milliseconds()
+		attempts = append(attempts, a)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, body, attempts, err
+		}
+	}
+}
+
+// do sends req and reads the whole response body.
+func do(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,196 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// OpKind describes a word-level edit.
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Insert
+	Delete
+)
+
+// Op is one run of text in a word-level diff.
+type Op struct {
+	Kind OpKind
+	Text string
+}
+
+// Entry is a single changed field between two sessions.
+type Entry struct {
+	Path string // e.g. "params.temperature", "messages[1].content", "response"
+	A    string // value in the first session, empty if absent
+	B    string // value in the second session, empty if absent
+	// Words holds a word-level diff for free-text fields.
+	Words []Op
+}
+
+// Result is the semantic difference between two sessions.
+type Result struct {
+	A, B    string // session IDs
+	Entries []Entry
+}
+
+// Equal reports whether no differences were found.
+func (r Result) Equal() bool { return len(r.Entries) == 0 }
+
+// Sessions compares the request parameters, messages and response content of
+// two sessions.
+func Sessions(a, b session.Session) Result {
+	res := Result{A: a.ID, B: b.ID}
+
+	pa, pb := a.Params(), b.Params()
+	for _, k := range unionKeys(pa, pb) {
+		va, vb := jsonValue(pa[k]), jsonValue(pb[k])
+		if va != vb {
+			res.Entries = append(res.Entries, Entry{Path: "params." + k, A: va, B: vb})
+		}
+	}
+
+	if ta, tb := a.PromptText(), b.PromptText(); ta != tb {
+		res.Entries = append(res.Entries, textEntry("prompt", ta, tb))
+	}
+
+	ma, mb := a.Messages(), b.Messages()
+	for i := 0; i < max(len(ma), len(mb)); i++ {
+		var x, y session.Message
+		if i < len(ma) {
+			x = ma[i]
+		}
+		if i < len(mb) {
+			y = mb[i]
+		}
+		if x.Role != y.Role {
+			res.Entries = append(res.Entries, Entry{Path: fmt.Sprintf("messages[%d].role", i), A: x.Role, B: y.Role})
+		}
+		if x.Content != y.Content {
+			res.Entries = append(res.Entries, textEntry(fmt.Sprintf("messages[%d].content", i), x.Content, y.Content))
+		}
+	}
+
+	if ta, tb := a.ResponseText(), b.ResponseText(); ta != tb {
+		res.Entries = append(res.Entries, textEntry("response", ta, tb))
+	}
+	return res
+}
+
+func textEntry(path, a, b string) Entry {
+	return Entry{Path: path, A: a, B: b, Words: Words(a, b)}
+}
+
+func unionKeys(a, b map[string]any) []string {
+	seen := map[string]struct{}{}
+	for k := range a {
+		seen[k] = struct{}{}
+	}
+	for k := range b {
+		seen[k] = struct{}{}
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func jsonValue(v any) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+var tokenRe = regexp.MustCompile(`\s+|[^\s]+`)
+
+// maxCells bounds the LCS table; larger inputs are diffed as a replacement.
+const maxCells = 4_000_000
+
+// Words returns a word-level diff turning a into b. Whitespace is kept so the
+// concatenated Equal and Delete ops reproduce a, and Equal and Insert ops
+// reproduce b.
+func Words(a, b string) []Op {
+	ta, tb := tokenRe.FindAllString(a, -1), tokenRe.FindAllString(b, -1)
+
+	// Trim the common prefix and suffix to keep the LCS table small.
+	pre := 0
+	for pre < len(ta) && pre < len(tb) && ta[pre] == tb[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(ta)-pre && suf < len(tb)-pre && ta[len(ta)-1-suf] == tb[len(tb)-1-suf] {
+		suf++
+	}
+
+	var ops []Op
+	ops = appendOp(ops, Equal, ta[:pre]...)
+	ma, mb := ta[pre:len(ta)-suf], tb[pre:len(tb)-suf]
+	if len(ma)*len(mb) > maxCells {
+		ops = appendOp(ops, Delete, ma...)
+		ops = appendOp(ops, Insert, mb...)
+	} else {
+		ops = append(ops, lcs(ma, mb)...)
+	}
+	return appendOp(ops, Equal, ta[len(ta)-suf:]...)
+}
+
+func lcs(a, b []string) []Op {
+	n, m := len(a), len(b)
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	var ops []Op
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = appendOp(ops, Equal, a[i])
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			ops = appendOp(ops, Delete, a[i])
+			i++
+		default:
+			ops = appendOp(ops, Insert, b[j])
+			j++
+		}
+	}
+	ops = appendOp(ops, Delete, a[i:]...)
+	return appendOp(ops, Insert, b[j:]...)
+}
+
+// appendOp appends tokens to ops, merging with the last op of the same kind.
+func appendOp(ops []Op, kind OpKind, tokens ...string) []Op {
+	if len(tokens) == 0 {
+		return ops
+	}
+	text := strings.Join(tokens, "")
+	if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Text: text})
+}
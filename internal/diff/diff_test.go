@@ -0,0 +1,77 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func join(ops []Op, skip OpKind) string {
+	var b strings.Builder
+	for _, op := range ops {
+		if op.Kind != skip {
+			b.WriteString(op.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestWords(t *testing.T) {
+	a := "the quick brown fox jumps"
+	b := "the quick red fox leaps high"
+	ops := Words(a, b)
+	if got := join(ops, Insert); got != a {
+		t.Fatalf("ops do not reproduce a: %q", got)
+	}
+	if got := join(ops, Delete); got != b {
+		t.Fatalf("ops do not reproduce b: %q", got)
+	}
+	var dels []string
+	for _, op := range ops {
+		if op.Kind == Delete {
+			dels = append(dels, strings.TrimSpace(op.Text))
+		}
+	}
+	if strings.Join(dels, ",") != "brown,jumps" {
+		t.Fatalf("unexpected deletions %v", dels)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	a := session.Session{
+		ID: "a",
+		Request: session.OpenAIRequest{Payload: map[string]any{
+			"model":       "gpt-4",
+			"temperature": 0.7,
+			"messages":    []any{map[string]any{"role": "user", "content": "hello there"}},
+		}},
+		Response: session.OpenAIResponse{Body: map[string]any{
+			"choices": []any{map[string]any{"message": map[string]any{"content": "hi friend"}}},
+		}},
+	}
+	b := session.Session{
+		ID: "b",
+		Request: session.OpenAIRequest{
+			Model:       "gpt-4o",
+			Temperature: 0.2,
+			Messages:    []session.Message{{Role: "user", Content: "hello there"}},
+		},
+		Response: session.OpenAIResponse{Choices: []session.Choice{{Message: session.Message{Content: "hi pal"}}}},
+	}
+
+	res := Sessions(a, b)
+	var paths []string
+	for _, e := range res.Entries {
+		paths = append(paths, e.Path)
+	}
+	if strings.Join(paths, ",") != "params.model,params.temperature,response" {
+		t.Fatalf("unexpected entries %v", paths)
+	}
+	if res.Entries[2].Words == nil {
+		t.Fatalf("expected word diff for response")
+	}
+	if Sessions(a, a).Equal() != true {
+		t.Fatalf("session should equal itself")
+	}
+}
@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	delStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	insStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	pathStyle = lipgloss.NewStyle().Bold(true)
+)
+
+// Format renders r as text. Word changes are marked git-style as [-removed-]
+// and {+added+}; colors are added when the terminal supports them.
+func Format(r Result) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", r.A, r.B)
+	if r.Equal() {
+		b.WriteString("no differences\n")
+		return b.String()
+	}
+	for _, e := range r.Entries {
+		b.WriteString("\n" + pathStyle.Render(e.Path) + "\n")
+		if e.Words == nil {
+			fmt.Fprintf(&b, "%s\n%s\n", delStyle.Render("- "+orNone(e.A)), insStyle.Render("+ "+orNone(e.B)))
+			continue
+		}
+		for _, op := range e.Words {
+			switch op.Kind {
+			case Equal:
+				b.WriteString(op.Text)
+			case Delete:
+				b.WriteString(delStyle.Render("[-" + op.Text + "-]"))
+			case Insert:
+				b.WriteString(insStyle.Render("{+" + op.Text + "+}"))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// SideBySide renders r as two columns of the given total width, the first
+// session on the left with removals highlighted and the second on the right
+// with additions highlighted.
+func SideBySide(r Result, width int) string {
+	col := (width - 3) / 2
+	if col < 10 {
+		col = 10
+	}
+	left := lipgloss.NewStyle().Width(col)
+	right := lipgloss.NewStyle().Width(col)
+
+	rows := []string{lipgloss.JoinHorizontal(lipgloss.Top,
+		left.Render(pathStyle.Render(r.A)), " | ", right.Render(pathStyle.Render(r.B)))}
+	if r.Equal() {
+		rows = append(rows, "no differences")
+	}
+	for _, e := range r.Entries {
+		a, b := e.A, e.B
+		if e.Words != nil {
+			a, b = renderSide(e.Words, Delete, delStyle), renderSide(e.Words, Insert, insStyle)
+		} else {
+			a, b = delStyle.Render(orNone(a)), insStyle.Render(orNone(b))
+		}
+		rows = append(rows, "", pathStyle.Render(e.Path),
+			lipgloss.JoinHorizontal(lipgloss.Top, left.Render(a), " | ", right.Render(b)))
+	}
+	return strings.Join(rows, "\n")
+}
+
+// renderSide renders the Equal ops plus the ops of kind, highlighted.
+func renderSide(ops []Op, kind OpKind, style lipgloss.Style) string {
+	var b strings.Builder
+	for _, op := range ops {
+		switch op.Kind {
+		case Equal:
+			b.WriteString(op.Text)
+		case kind:
+			b.WriteString(style.Underline(true).Render(op.Text))
+		}
+	}
+	return b.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,188 @@
+package fault
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Header forces a fault of the named kind on a single request.
+const Header = "X-Promptkit-Fault"
+
+// DefaultDelay is used by timeouts and slow first tokens without a delay.
+const DefaultDelay = 10 * time.Second
+
+// Kind is a failure mode.
+type Kind string
+
+const (
+	RateLimit      Kind = "rate_limit"       // 429 without calling the backend
+	ServerError    Kind = "server_error"     // 500 without calling the backend
+	Timeout        Kind = "timeout"          // hang for Delay, then drop the connection
+	Truncate       Kind = "truncate"         // cut the response off halfway
+	SlowFirstToken Kind = "slow_first_token" // wait Delay before the first byte
+	MalformedJSON  Kind = "malformed_json"   // corrupt the JSON of the response
+)
+
+var kinds = []Kind{RateLimit, ServerError, Timeout, Truncate, SlowFirstToken, MalformedJSON}
+
+// Upstream reports whether the fault is applied to a backend response rather
+// than replacing the call.
+func (k Kind) Upstream() bool {
+	return k == Truncate || k == SlowFirstToken || k == MalformedJSON
+}
+
+// Rule injects Kind into Percent of the requests in its scope. Model is a
+// name or glob pattern; Header is "Name: value" or just "Name" to match any
+// value. Empty scopes match every request.
+type Rule struct {
+	Kind    Kind          `yaml:"kind"`
+	Percent float64       `yaml:"percent"`
+	Model   string        `yaml:"model"`
+	Header  string        `yaml:"header"`
+	Delay   time.Duration `yaml:"delay"`
+}
+
+func (r Rule) matches(model string, h http.Header) bool {
+	if r.Model != "" {
+		if ok, _ := path.Match(r.Model, model); !ok {
+			return false
+		}
+	}
+	if r.Header != "" {
+		name, value, hasValue := strings.Cut(r.Header, ":")
+		got := h.Values(strings.TrimSpace(name))
+		if len(got) == 0 {
+			return false
+		}
+		if hasValue && strings.TrimSpace(got[0]) != strings.TrimSpace(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is the fault file format.
+type Config struct {
+	Faults []Rule `yaml:"faults"`
+}
+
+// Injector picks faults for requests.
+type Injector struct {
+	rules []Rule
+	roll  func() float64 // uniform in [0, 100)
+}
+
+// New returns an injector for rules.
+func New(rules []Rule) (*Injector, error) {
+	for i, r := range rules {
+		if !valid(r.Kind) {
+			return nil, fmt.Errorf("fault %d: unknown kind %q", i+1, r.Kind)
+		}
+		if r.Percent < 0 || r.Percent > 100 {
+			return nil, fmt.Errorf("fault %d: percent must be between 0 and 100", i+1)
+		}
+	}
+	return &Injector{rules: rules, roll: func() float64 { return rand.Float64() * 100 }}, nil
+}
+
+// Load reads faults from a YAML file.
+func Load(file string) (*Injector, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return New(cfg.Faults)
+}
+
+func valid(k Kind) bool {
+	for _, kind := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Pick returns the fault to inject into a request, if any. A valid Header
+// value forces that fault; otherwise each matching rule rolls its dice in
+// order and the first hit wins. Without a faults file the daemon's injector
+// is nil and never injects anything, not even forced faults.
+func (i *Injector) Pick(model string, h http.Header) *Rule {
+	if i == nil {
+		return nil
+	}
+	if k := Kind(h.Get(Header)); valid(k) {
+		for _, r := range i.rules {
+			if r.Kind == k {
+				return &r
+			}
+		}
+		return &Rule{Kind: k, Percent: 100}
+	}
+	for _, r := range i.rules {
+		if r.matches(model, h) && i.roll() < r.Percent {
+			return &r
+		}
+	}
+	return nil
+}
+
+// DelayOrDefault returns the rule's delay.
+func (r Rule) DelayOrDefault() time.Duration {
+	if r.Delay > 0 {
+		return r.Delay
+	}
+	return DefaultDelay
+}
+
+// Truncated returns the first half of a response body. Streams are cut after
+// the last complete event in the first half, so clients see a stream that
+// ends without [DONE].
+func Truncated(body []byte) []byte {
+	half := body[:len(body)/2]
+	if i := bytes.LastIndex(half, []byte("\n\n")); i >= 0 {
+		return half[:i+2]
+	}
+	return half
+}
+
+// Malformed corrupts the JSON of a response body. In a stream, the middle
+// data event is corrupted.
+func Malformed(body []byte) []byte {
+	events := bytes.Split(body, []byte("\n\n"))
+	var data []int
+	for i, ev := range events {
+		if bytes.HasPrefix(ev, []byte("data: {")) {
+			data = append(data, i)
+		}
+	}
+	if len(data) == 0 {
+		return corrupt(body)
+	}
+	mid := data[len(data)/2]
+	events[mid] = corrupt(events[mid])
+	return bytes.Join(events, []byte("\n\n"))
+}
+
+// corrupt inserts a trailing comma before the last closing brace.
+func corrupt(b []byte) []byte {
+	i := bytes.LastIndexByte(b, '}')
+	if i < 0 {
+		return append(b, '{')
+	}
+	out := append([]byte{}, b[:i]...)
+	out = append(out, ',')
+	return append(out, b[i:]...)
+}
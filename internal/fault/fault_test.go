@@ -0,0 +1,68 @@
+package fault
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestPick(t *testing.T) {
+	inj, err := New([]Rule{
+		{Kind: RateLimit, Percent: 50, Model: "gpt-4o*"},
+		{Kind: ServerError, Percent: 100, Header: "X-Chaos: on"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roll := 0.0
+	inj.roll = func() float64 { return roll }
+
+	if f := inj.Pick("gpt-4o-mini", http.Header{}); f == nil || f.Kind != RateLimit {
+		t.Errorf("Pick(gpt-4o-mini) = %v, want rate_limit", f)
+	}
+	roll = 75
+	if f := inj.Pick("gpt-4o-mini", http.Header{}); f != nil {
+		t.Errorf("Pick above percent = %v, want none", f)
+	}
+	if f := inj.Pick("llama", http.Header{"X-Chaos": {"on"}}); f == nil || f.Kind != ServerError {
+		t.Errorf("Pick with header = %v, want server_error", f)
+	}
+	if f := inj.Pick("llama", http.Header{"X-Chaos": {"off"}}); f != nil {
+		t.Errorf("Pick with other header value = %v, want none", f)
+	}
+	if f := inj.Pick("llama", http.Header{Header: {"truncate"}}); f == nil || f.Kind != Truncate {
+		t.Errorf("forced Pick = %v, want truncate", f)
+	}
+
+	var none *Injector
+	if f := none.Pick("m", http.Header{Header: {"timeout"}}); f != nil {
+		t.Errorf("nil injector picked %v", f)
+	}
+	if _, err := New([]Rule{{Kind: "explode"}}); err == nil {
+		t.Error("unknown kind accepted")
+	}
+}
+
+func TestMutations(t *testing.T) {
+	stream := []byte("data: {\"a\":1}\n\ndata: {\"a\":2}\n\ndata: {\"a\":3}\n\ndata: [DONE]\n\n")
+	cut := Truncated(stream)
+	if !bytes.HasSuffix(cut, []byte("\n\n")) || bytes.Contains(cut, []byte("[DONE]")) || len(cut) == 0 {
+		t.Errorf("Truncated(stream) = %q", cut)
+	}
+
+	if json.Valid(Malformed([]byte(`{"a":1}`))) {
+		t.Error("Malformed JSON is valid")
+	}
+	bad := Malformed(stream)
+	events := bytes.Split(bad, []byte("\n\n"))
+	invalid := 0
+	for _, ev := range events {
+		if data, ok := bytes.CutPrefix(ev, []byte("data: ")); ok && !bytes.Equal(data, []byte("[DONE]")) && !json.Valid(data) {
+			invalid++
+		}
+	}
+	if invalid != 1 {
+		t.Errorf("Malformed(stream) corrupted %d events, want 1: %q", invalid, bad)
+	}
+}
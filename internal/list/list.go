@@ -1,12 +1,11 @@
 package list
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -14,6 +13,7 @@ import (
 	"text/tabwriter"
 	"time"
 
+	"github.com/promptkit/promptkit/internal/sessionfile"
 	"github.com/promptkit/promptkit/pkg/session"
 )
 
@@ -36,36 +36,38 @@ func ParseFilter(expr string) (FilterFunc, error) {
 	valStr := m[3]
 
 	return func(data map[string]any) bool {
-		v, _ := getPathValue(data, path)
+		v, ok := getPathValue(data, path)
+		if !ok && len(path) == 1 {
+			// Bare metadata fields: backend=openai, status=rejected.
+			v, _ = getPathValue(data, []string{"metadata", path[0]})
+		}
 		return compare(v, op, valStr)
 	}, nil
 }
 
 // LoadSessions reads all sessions from the given directory, sorted by timestamp descending.
 func LoadSessions(dir string) ([]session.Session, error) {
-	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	files, err := sessionfile.Files(dir)
 	if err != nil {
 		return nil, err
 	}
 	var sessions []session.Session
+	dec := sessionfile.NewDecoder()
 	for _, f := range files {
-		file, err := os.Open(f)
-		if err != nil {
-			return nil, err
-		}
-		sc := bufio.NewScanner(file)
-		for sc.Scan() {
-			var s session.Session
-			if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+		err := dec.Scan(f, func(s session.Session, err error) bool {
+			if err != nil {
 				log.Printf("skip corrupt session in %s: %v", f, err)
-				continue
+				return true
 			}
 			sessions = append(sessions, s)
+			return true
+		})
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return nil, err
+		} else if err != nil {
+			log.Print(err)
 		}
-		if err := sc.Err(); err != nil {
-			log.Printf("reading %s: %v", f, err)
-		}
-		file.Close()
 	}
 	sort.Slice(sessions, func(i, j int) bool {
 		return sessions[i].Metadata.Timestamp.After(sessions[j].Metadata.Timestamp)
@@ -170,6 +172,7 @@ type Summary struct {
 	Model     string   `json:"model"`
 	Origin    string   `json:"origin"`
 	Tokens    int      `json:"tokens"`
+	CostUSD   float64  `json:"cost_usd"`
 	LatencyMS int64    `json:"latency_ms"`
 	Tags      []string `json:"tags"`
 	Published string   `json:"published"`
@@ -184,6 +187,9 @@ func Summarize(s session.Session) Summary {
 	}
 	tokensVal, _ := getPathValue(m, []string{"response", "usage", "total_tokens"})
 	tokens, _ := toFloat64(tokensVal)
+	if tokens == 0 && s.Metadata.Usage != nil {
+		tokens = float64(s.Metadata.Usage.PromptTokens + s.Metadata.Usage.CompletionTokens)
+	}
 
 	pub := ""
 	if s.Metadata.Published != nil {
@@ -194,6 +200,7 @@ func Summarize(s session.Session) Summary {
 		Model:     fmt.Sprint(model),
 		Origin:    string(s.Origin),
 		Tokens:    int(tokens),
+		CostUSD:   s.Metadata.CostUSD,
 		LatencyMS: s.Metadata.LatencyMS,
 		Tags:      s.Metadata.Tags,
 		Published: pub,
@@ -203,12 +210,23 @@ func Summarize(s session.Session) Summary {
 // PrintTable prints summaries in a simple table format.
 func PrintTable(summaries []Summary) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
-	fmt.Fprintln(w, "ID\tModel\tOrigin\tTokens\tLatency\tTags\tPublished")
-	fmt.Fprintln(w, "--\t-----\t------\t------\t-------\t----\t---------")
+	fmt.Fprintln(w, "ID\tModel\tOrigin\tTokens\tCost\tLatency\tTags\tPublished")
+	fmt.Fprintln(w, "--\t-----\t------\t------\t----\t-------\t----\t---------")
 	for _, s := range summaries {
 		tags := strings.Join(s.Tags, ", ")
 		latency := fmt.Sprintf("%dms", s.LatencyMS)
-		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", s.ID, s.Model, s.Origin, s.Tokens, latency, tags, s.Published)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", s.ID, s.Model, s.Origin, s.Tokens, FormatCost(s.CostUSD), latency, tags, s.Published)
 	}
 	w.Flush()
 }
+
+// FormatCost formats a USD amount for display, or "-" if it is unknown.
+func FormatCost(usd float64) string {
+	if usd == 0 {
+		return "-"
+	}
+	if usd < 0.01 {
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
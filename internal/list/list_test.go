@@ -45,6 +45,18 @@ func TestFilterOperators(t *testing.T) {
 	}
 }
 
+func TestFilterMetadataShorthand(t *testing.T) {
+	pred, _ := ParseFilter("backend=azure")
+	m := map[string]any{"origin": "proxy", "metadata": map[string]any{"backend": "azure"}}
+	if !pred(m) {
+		t.Fatalf("backend shorthand failed")
+	}
+	pred, _ = ParseFilter("origin=proxy")
+	if !pred(m) {
+		t.Fatalf("top-level field shadowed")
+	}
+}
+
 func TestSummarize(t *testing.T) {
 	pub := "oci://reg/app:1"
 	s := session.Session{
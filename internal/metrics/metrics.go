@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+const namespace = "promptkit"
+
+// maxModels bounds the model label. Models first seen after that many are
+// counted as "other".
+const maxModels = 100
+
+// Outcome says how the daemon handled a request.
+type Outcome string
+
+const (
+	Forwarded   Outcome = "forwarded"    // the backend answered
+	Unreachable Outcome = "unreachable"  // no backend could be reached
+	CacheHit    Outcome = "cache_hit"    // served from the response cache
+	Budget      Outcome = "budget"       // rejected over a budget
+	RateLimited Outcome = "rate_limited" // rejected by a rate limit
+	Fault       Outcome = "fault"        // answered with an injected fault
+	Refused     Outcome = "refused"      // invalid request, unknown key or no backend
+)
+
+// Metrics holds the daemon's Prometheus collectors. The daemon leaves them
+// nil when it has no admin address; a nil *Metrics records nothing.
+type Metrics struct {
+	reg             *prometheus.Registry
+	requests        *prometheus.CounterVec
+	upstreamLatency *prometheus.HistogramVec
+	upstreamErrors  *prometheus.CounterVec
+	bytes           *prometheus.CounterVec
+	tokens          *prometheus.CounterVec
+	recordFailures  prometheus.Counter
+
+	mu     sync.Mutex
+	models map[string]bool
+}
+
+// New creates and registers the daemon metrics.
+func New() *Metrics {
+	m := &Metrics{
+		reg:    prometheus.NewRegistry(),
+		models: map[string]bool{},
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests by method, endpoint, response status and outcome.",
+		}, []string{"method", "endpoint", "status", "outcome"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_latency_seconds",
+			Help:      "Time until the upstream response was fully read.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80},
+		}, []string{"endpoint"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_errors_total",
+			Help:      "Upstream failures by HTTP status code, or \"transport\" when no response was received.",
+		}, []string{"endpoint", "code"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxied_bytes_total",
+			Help:      "Bytes of request and response bodies passed through the proxy.",
+		}, []string{"direction"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_total",
+			Help:      "Tokens reported in recorded sessions by model and type.",
+		}, []string{"model", "type"}),
+		recordFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "recorder_write_failures_total",
+			Help:      "Sessions that could not be written to the session log.",
+		}),
+	}
+	m.reg.MustRegister(
+		m.requests, m.upstreamLatency, m.upstreamErrors, m.bytes, m.tokens, m.recordFailures,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{Registry: m.reg})
+}
+
+// Endpoint maps a request path to a bounded label value.
+func Endpoint(path string) string {
+	switch path {
+	case "/v1/completions", "/v1/chat/completions", "/v1/embeddings", "/v1/models":
+		return path
+	}
+	return "other"
+}
+
+// ObserveRequest records a request forwarded to a backend and its upstream
+// outcome. status is 0 when the upstream could not be reached.
+func (m *Metrics) ObserveRequest(method, path string, status int, upstream time.Duration, reqBytes, respBytes int) {
+	if m == nil {
+		return
+	}
+	ep := Endpoint(path)
+	code := strconv.Itoa(status)
+	if status == 0 {
+		code = "transport"
+		m.requests.WithLabelValues(method, ep, strconv.Itoa(http.StatusBadGateway), string(Unreachable)).Inc()
+	} else {
+		m.requests.WithLabelValues(method, ep, code, string(Forwarded)).Inc()
+		m.upstreamLatency.WithLabelValues(ep).Observe(upstream.Seconds())
+	}
+	if status == 0 || status >= 400 {
+		m.upstreamErrors.WithLabelValues(ep, code).Inc()
+	}
+	m.bytes.WithLabelValues("request").Add(float64(reqBytes))
+	m.bytes.WithLabelValues("response").Add(float64(respBytes))
+}
+
+// ObserveAnswered records a request the daemon answered itself with status,
+// without a response from a backend.
+func (m *Metrics) ObserveAnswered(method, path string, status int, outcome Outcome) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(method, Endpoint(path), strconv.Itoa(status), string(outcome)).Inc()
+}
+
+// ObserveSession records token usage of a recorded session, preferring the
+// usage the daemon computed over the one in the response body.
+func (m *Metrics) ObserveSession(s session.Session) {
+	if m == nil {
+		return
+	}
+	var prompt, completion int
+	if u := s.Metadata.Usage; u != nil {
+		prompt, completion = u.PromptTokens, u.CompletionTokens
+	} else {
+		u := s.Usage()
+		prompt, completion = u.PromptTokens, u.CompletionTokens
+	}
+	model := m.model(s.Model())
+	m.tokens.WithLabelValues(model, "prompt").Add(float64(prompt))
+	m.tokens.WithLabelValues(model, "completion").Add(float64(completion))
+}
+
+// model maps a model name to a bounded label value.
+func (m *Metrics) model(name string) string {
+	if name == "" {
+		return "unknown"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.models[name] {
+		if len(m.models) >= maxModels {
+			return "other"
+		}
+		m.models[name] = true
+	}
+	return name
+}
+
+// RecordFailed counts a session that could not be written.
+func (m *Metrics) RecordFailed() {
+	if m == nil {
+		return
+	}
+	m.recordFailures.Inc()
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestOutcomes(t *testing.T) {
+	m := New()
+	m.ObserveRequest("POST", "/v1/chat/completions", 200, time.Second, 10, 20)
+	m.ObserveRequest("POST", "/v1/chat/completions", 0, 0, 10, 0)
+	m.ObserveAnswered("POST", "/v1/chat/completions", 200, CacheHit)
+	m.ObserveAnswered("POST", "/v1/chat/completions", 429, Budget)
+	m.ObserveAnswered("POST", "/v1/chat/completions", 429, RateLimited)
+	m.ObserveAnswered("POST", "/v1/chat/completions", 500, Fault)
+	m.ObserveAnswered("GET", "/v2/unknown", 401, Refused)
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="forwarded",status="200"} 1`,
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="unreachable",status="502"} 1`,
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="cache_hit",status="200"} 1`,
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="budget",status="429"} 1`,
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="rate_limited",status="429"} 1`,
+		`promptkit_requests_total{endpoint="/v1/chat/completions",method="POST",outcome="fault",status="500"} 1`,
+		`promptkit_requests_total{endpoint="other",method="GET",outcome="refused",status="401"} 1`,
+		`promptkit_upstream_errors_total{code="transport",endpoint="/v1/chat/completions"} 1`,
+		`promptkit_upstream_latency_seconds_count{endpoint="/v1/chat/completions"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	m := New()
+	// Streamed responses carry no usage; the daemon's estimate is used.
+	s := session.Session{Request: session.OpenAIRequest{Model: "gpt-4o"}}
+	s.Metadata.Usage = &session.TokenUsage{PromptTokens: 7, CompletionTokens: 3, Estimated: true}
+	m.ObserveSession(s)
+	for i := 0; i < maxModels+5; i++ {
+		m.ObserveSession(session.Session{Request: session.OpenAIRequest{Model: fmt.Sprintf("m%d", i)}})
+	}
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`promptkit_tokens_total{model="gpt-4o",type="prompt"} 7`,
+		`promptkit_tokens_total{model="gpt-4o",type="completion"} 3`,
+		`promptkit_tokens_total{model="other",type="prompt"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(out, fmt.Sprintf(`model="m%d"`, maxModels)) {
+		t.Error("model label not bounded")
+	}
+}
@@ -0,0 +1,187 @@
+package mock
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Defaults for streamed responses.
+const (
+	DefaultChunkSize = 4 // runes per content delta
+)
+
+// File is the mock definition format.
+type File struct {
+	// Stream applies to every mock that does not set its own.
+	Stream Stream `yaml:"stream"`
+	Mocks  []Mock `yaml:"mocks"`
+}
+
+// Mock returns Response for requests that satisfy Match. Mocks are tried in
+// file order; the first match wins.
+type Mock struct {
+	Name     string   `yaml:"name"`
+	Match    Match    `yaml:"match"`
+	Response Response `yaml:"response"`
+	Stream   *Stream  `yaml:"stream"`
+}
+
+// Match selects requests. Empty fields match anything.
+type Match struct {
+	// Model is a model name or glob pattern.
+	Model string `yaml:"model"`
+	// Message is a regular expression matched against the last user message,
+	// or the prompt of a completion request.
+	Message string `yaml:"message"`
+	// Tool requires the request to offer a tool or function of this name.
+	Tool string `yaml:"tool"`
+
+	messageRe *regexp.Regexp
+}
+
+// Response is a canned completion, or an API error if Status is 400 or more.
+type Response struct {
+	Content      string     `yaml:"content"`
+	ToolCalls    []ToolCall `yaml:"tool_calls"`
+	FinishReason string     `yaml:"finish_reason"`
+	Usage        *Usage     `yaml:"usage"`
+	Status       int        `yaml:"status"`
+	Error        string     `yaml:"error"`
+}
+
+// ToolCall is a function call made by the mocked assistant.
+type ToolCall struct {
+	Name      string `yaml:"name"`
+	Arguments string `yaml:"arguments"`
+}
+
+// Usage is the token usage reported with a response.
+type Usage struct {
+	PromptTokens     int `yaml:"prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int `yaml:"completion_tokens" json:"completion_tokens"`
+	TotalTokens      int `yaml:"total_tokens" json:"total_tokens"`
+}
+
+// Stream controls how responses are streamed when a request sets stream.
+type Stream struct {
+	// ChunkSize is the number of runes of content per chunk.
+	ChunkSize int `yaml:"chunk_size"`
+	// Delay is the pause between chunks.
+	Delay time.Duration `yaml:"delay"`
+	// FirstTokenDelay is the pause before the first chunk.
+	FirstTokenDelay time.Duration `yaml:"first_token_delay"`
+}
+
+// Load reads and validates a mock file.
+func Load(file string) (*File, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	if err := f.compile(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &f, nil
+}
+
+func (f *File) compile() error {
+	for i := range f.Mocks {
+		m := &f.Mocks[i]
+		if m.Name == "" {
+			m.Name = fmt.Sprintf("mock %d", i+1)
+		}
+		if m.Match.Message != "" {
+			re, err := regexp.Compile(m.Match.Message)
+			if err != nil {
+				return fmt.Errorf("%s: message: %w", m.Name, err)
+			}
+			m.Match.messageRe = re
+		}
+		if m.Match.Model != "" {
+			if _, err := path.Match(m.Match.Model, ""); err != nil {
+				return fmt.Errorf("%s: model: %w", m.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Find returns the first mock matching a request.
+func (f *File) Find(s session.Session) *Mock {
+	for i := range f.Mocks {
+		if f.Mocks[i].Match.matches(s) {
+			return &f.Mocks[i]
+		}
+	}
+	return nil
+}
+
+func (m Match) matches(s session.Session) bool {
+	if m.Model != "" {
+		if ok, _ := path.Match(m.Model, s.Model()); !ok {
+			return false
+		}
+	}
+	if m.messageRe != nil && !m.messageRe.MatchString(lastUserMessage(s)) {
+		return false
+	}
+	if m.Tool != "" && !offersTool(s, m.Tool) {
+		return false
+	}
+	return true
+}
+
+func lastUserMessage(s session.Session) string {
+	msgs := s.Messages()
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "user" {
+			return msgs[i].Content
+		}
+	}
+	return s.PromptText()
+}
+
+// offersTool reports whether the request declares a tool, or legacy
+// function, with the given name.
+func offersTool(s session.Session, name string) bool {
+	p := s.RequestPayload()
+	tools, _ := p["tools"].([]any)
+	for _, t := range tools {
+		tool, _ := t.(map[string]any)
+		fn, _ := tool["function"].(map[string]any)
+		if fn["name"] == name {
+			return true
+		}
+	}
+	funcs, _ := p["functions"].([]any)
+	for _, f := range funcs {
+		fn, _ := f.(map[string]any)
+		if fn["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// streamFor returns the effective stream settings of a mock.
+func (f *File) streamFor(m *Mock) Stream {
+	st := f.Stream
+	if m.Stream != nil {
+		st = *m.Stream
+	}
+	if st.ChunkSize <= 0 {
+		st.ChunkSize = DefaultChunkSize
+	}
+	return st
+}
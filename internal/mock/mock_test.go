@@ -0,0 +1,164 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fixture = `
+stream:
+  chunk_size: 3
+mocks:
+  - name: weather
+    match:
+      tool: get_weather
+    response:
+      tool_calls:
+        - name: get_weather
+          arguments: '{"city":"Paris"}'
+  - name: greeting
+    match:
+      model: gpt-4o*
+      message: (?i)^hello
+    response:
+      content: Hi there!
+      usage: {prompt_tokens: 5, completion_tokens: 3}
+  - name: overloaded
+    match:
+      message: overload
+    response:
+      status: 503
+      error: backend overloaded
+`
+
+func load(t *testing.T) *File {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "mocks.yaml")
+	if err := os.WriteFile(file, []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func post(t *testing.T, h http.Handler, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rr
+}
+
+func TestMatch(t *testing.T) {
+	h := Handler(load(t))
+
+	rr := post(t, h, "/v1/chat/completions", `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"Hello!"}]}`)
+	var resp struct {
+		Object  string
+		Choices []struct {
+			Message struct {
+				Content   string
+				ToolCalls []struct {
+					Function struct{ Name, Arguments string }
+				} `json:"tool_calls"`
+			}
+			FinishReason string `json:"finish_reason"`
+		}
+		Usage Usage
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || resp.Choices[0].Message.Content != "Hi there!" || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("greeting = %s", rr.Body)
+	}
+	if resp.Usage.TotalTokens != 8 {
+		t.Errorf("usage = %+v, want total 8", resp.Usage)
+	}
+
+	rr = post(t, h, "/v1/chat/completions", `{"model":"llama","messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]}`)
+	resp.Choices = nil
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || resp.Choices[0].FinishReason != "tool_calls" || len(resp.Choices[0].Message.ToolCalls) != 1 ||
+		resp.Choices[0].Message.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool call = %s", rr.Body)
+	}
+
+	if rr := post(t, h, "/v1/chat/completions", `{"model":"llama","messages":[{"role":"user","content":"Hello"}]}`); rr.Code != http.StatusNotFound {
+		t.Errorf("unmatched model: status %d, want 404", rr.Code)
+	}
+	rr = post(t, h, "/v1/completions", `{"model":"llama","prompt":"please overload"}`)
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "backend overloaded") {
+		t.Errorf("error mock: %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestStream(t *testing.T) {
+	h := Handler(load(t))
+	rr := post(t, h, "/v1/chat/completions", `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hello"}]}`)
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var content strings.Builder
+	var finish string
+	var usage *Usage
+	events := strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n")
+	if events[len(events)-1] != "data: [DONE]" {
+		t.Errorf("last event = %q", events[len(events)-1])
+	}
+	chunks := 0
+	for _, ev := range events[:len(events)-1] {
+		var chunk struct {
+			Choices []struct {
+				Delta        struct{ Content string }
+				FinishReason *string `json:"finish_reason"`
+			}
+			Usage *Usage
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(ev, "data: ")), &chunk); err != nil {
+			t.Fatalf("event %q: %v", ev, err)
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				chunks++
+			}
+			content.WriteString(c.Delta.Content)
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if content.String() != "Hi there!" || chunks != 3 {
+		t.Errorf("content = %q in %d chunks, want 3", content.String(), chunks)
+	}
+	if finish != "stop" || usage == nil || usage.CompletionTokens != 3 {
+		t.Errorf("finish = %q, usage = %+v", finish, usage)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mocks.yaml")
+	os.WriteFile(file, []byte("mocks:\n  - match: {message: '('}\n"), 0o644)
+	if _, err := Load(file); err == nil {
+		t.Error("invalid regexp accepted")
+	}
+}
+
+func TestListModelsEmpty(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Handler(load(t)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"data":[],"object":"list"}` {
+		t.Fatalf("models = %s", got)
+	}
+}
@@ -0,0 +1,270 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/promptkit/promptkit/internal/tokens"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Handler serves f as an OpenAI-compatible API.
+func Handler(f *File) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) { serve(f, w, r, true) })
+	r.Post("/v1/completions", func(w http.ResponseWriter, r *http.Request) { serve(f, w, r, false) })
+	r.Get("/v1/models", func(w http.ResponseWriter, r *http.Request) { listModels(f, w) })
+	return r
+}
+
+func serve(f *File, w http.ResponseWriter, r *http.Request, chat bool) {
+	var payload map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON body: "+err.Error())
+		return
+	}
+	req := session.Session{Request: session.OpenAIRequest{Payload: payload}}
+	m := f.Find(req)
+	if m == nil {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "no mock matches this request")
+		return
+	}
+	resp := m.Response
+	if resp.Status >= 400 {
+		msg := resp.Error
+		if msg == "" {
+			msg = http.StatusText(resp.Status)
+		}
+		writeError(w, resp.Status, errorType(resp.Status), msg)
+		return
+	}
+
+	c := completion{
+		id:      fmt.Sprintf("mock-%d", time.Now().UnixNano()),
+		created: time.Now().Unix(),
+		model:   req.Model(),
+		chat:    chat,
+		resp:    resp,
+		usage:   usage(req, resp),
+	}
+	if c.resp.FinishReason == "" {
+		c.resp.FinishReason = "stop"
+		if chat && len(resp.ToolCalls) > 0 {
+			c.resp.FinishReason = "tool_calls"
+		}
+	}
+
+	if stream, _ := payload["stream"].(bool); stream {
+		opts, _ := payload["stream_options"].(map[string]any)
+		includeUsage, _ := opts["include_usage"].(bool)
+		c.stream(r.Context(), w, f.streamFor(m), includeUsage)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.full())
+}
+
+// usage returns the configured usage, estimating unset counts.
+func usage(req session.Session, resp Response) Usage {
+	var u Usage
+	if resp.Usage != nil {
+		u = *resp.Usage
+	}
+	if u.PromptTokens == 0 {
+		u.PromptTokens = tokens.Prompt(req)
+	}
+	if u.CompletionTokens == 0 {
+		u.CompletionTokens = tokens.Count(resp.Content)
+		for _, tc := range resp.ToolCalls {
+			u.CompletionTokens += tokens.Count(tc.Name) + tokens.Count(tc.Arguments)
+		}
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return u
+}
+
+type completion struct {
+	id      string
+	created int64
+	model   string
+	chat    bool
+	resp    Response
+	usage   Usage
+}
+
+func (c completion) toolCalls() []map[string]any {
+	var calls []map[string]any
+	for i, tc := range c.resp.ToolCalls {
+		calls = append(calls, map[string]any{
+			"index":    i,
+			"id":       fmt.Sprintf("call_%s_%d", c.id, i),
+			"type":     "function",
+			"function": map[string]any{"name": tc.Name, "arguments": tc.Arguments},
+		})
+	}
+	return calls
+}
+
+func (c completion) object(streamed bool) string {
+	switch {
+	case c.chat && streamed:
+		return "chat.completion.chunk"
+	case c.chat:
+		return "chat.completion"
+	}
+	return "text_completion"
+}
+
+func (c completion) envelope(streamed bool, choices []map[string]any) map[string]any {
+	return map[string]any{
+		"id":      c.id,
+		"object":  c.object(streamed),
+		"created": c.created,
+		"model":   c.model,
+		"choices": choices,
+	}
+}
+
+// full returns the non-streamed response.
+func (c completion) full() map[string]any {
+	choice := map[string]any{"index": 0, "finish_reason": c.resp.FinishReason}
+	if c.chat {
+		msg := map[string]any{"role": "assistant", "content": c.resp.Content}
+		if calls := c.toolCalls(); calls != nil {
+			msg["tool_calls"] = calls
+		}
+		choice["message"] = msg
+	} else {
+		choice["text"] = c.resp.Content
+	}
+	body := c.envelope(false, []map[string]any{choice})
+	body["usage"] = c.usage
+	return body
+}
+
+// chunk returns a streamed chunk carrying delta, or text for completions.
+func (c completion) chunk(delta map[string]any, finish any) map[string]any {
+	choice := map[string]any{"index": 0, "finish_reason": finish}
+	if c.chat {
+		choice["delta"] = delta
+	} else {
+		text, _ := delta["content"].(string)
+		choice["text"] = text
+	}
+	return c.envelope(true, []map[string]any{choice})
+}
+
+// stream writes the response as server-sent events, splitting the content
+// into chunks of st.ChunkSize runes.
+func (c completion) stream(ctx context.Context, w http.ResponseWriter, st Stream, includeUsage bool) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(v any) {
+		b, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	wait := func(d time.Duration) bool {
+		if d <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(d):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if c.chat {
+		send(c.chunk(map[string]any{"role": "assistant", "content": ""}, nil))
+	}
+	if !wait(st.FirstTokenDelay) {
+		return
+	}
+	for i, piece := range split(c.resp.Content, st.ChunkSize) {
+		if i > 0 && !wait(st.Delay) {
+			return
+		}
+		send(c.chunk(map[string]any{"content": piece}, nil))
+	}
+	if calls := c.toolCalls(); c.chat && calls != nil {
+		if !wait(st.Delay) {
+			return
+		}
+		send(c.chunk(map[string]any{"tool_calls": calls}, nil))
+	}
+	send(c.chunk(map[string]any{}, c.resp.FinishReason))
+	if includeUsage {
+		body := c.envelope(true, []map[string]any{})
+		body["usage"] = c.usage
+		send(body)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// split cuts s into pieces of n runes.
+func split(s string, n int) []string {
+	var out []string
+	runes := []rune(s)
+	for len(runes) > 0 {
+		k := min(n, len(runes))
+		out = append(out, string(runes[:k]))
+		runes = runes[k:]
+	}
+	return out
+}
+
+func listModels(f *File, w http.ResponseWriter) {
+	seen := map[string]bool{}
+	data := []map[string]any{}
+	for _, m := range f.Mocks {
+		name := m.Match.Model
+		if name == "" || seen[name] || strings.ContainsAny(name, "*?[") {
+			continue
+		}
+		seen[name] = true
+		data = append(data, map[string]any{"id": name, "object": "model", "owned_by": "promptkit-mock"})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i]["id"].(string) < data[j]["id"].(string) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": data})
+}
+
+func errorType(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "requests"
+	case status >= 500:
+		return "server_error"
+	}
+	return "invalid_request_error"
+}
+
+func writeError(w http.ResponseWriter, status int, typ, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{
+		"message": msg,
+		"type":    typ,
+		"param":   nil,
+		"code":    nil,
+	}})
+}
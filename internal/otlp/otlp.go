@@ -0,0 +1,353 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/trace"
+	"github.com/promptkit/promptkit/pkg/session"
+	"github.com/promptkit/promptkit/pkg/version"
+)
+
+const (
+	batchSize     = 64
+	flushInterval = 2 * time.Second
+	queueSize     = 1024
+)
+
+// Config configures the exporter.
+type Config struct {
+	// Endpoint is the OTLP/HTTP base URL, e.g. http://localhost:4318. Spans
+	// are posted to Endpoint + "/v1/traces".
+	Endpoint string
+	Headers  map[string]string
+	// CaptureContent adds the prompt and completion as span events.
+	CaptureContent bool
+	ServiceName    string
+	Client         *http.Client
+}
+
+// Exporter sends recorded sessions as OTLP spans in the background.
+type Exporter struct {
+	cfg   Config
+	url   string
+	queue chan session.Session
+	done  chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewExporter starts an exporter for cfg.
+func NewExporter(cfg Config) *Exporter {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "promptkit"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	e := &Exporter{
+		cfg:   cfg,
+		url:   strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		queue: make(chan session.Session, queueSize),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues s for export. Sessions are dropped if the queue is full so a
+// slow collector never holds up the proxy.
+func (e *Exporter) Export(s session.Session) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		log.Printf("otlp: queue full, dropping session %s", s.ID)
+	}
+}
+
+// Close flushes queued sessions and stops the exporter.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+	return nil
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []session.Session
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.flush(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				e.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+func (e *Exporter) flush(batch []session.Session) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.send(batch); err != nil {
+		log.Printf("otlp: export %d spans: %v", len(batch), err)
+	}
+}
+
+func (e *Exporter) send(batch []session.Session) error {
+	spans := make([]Span, len(batch))
+	for i, s := range batch {
+		spans[i] = FromSession(s, e.cfg.CaptureContent)
+	}
+	body, err := json.Marshal(request{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []KeyValue{
+			str("service.name", e.cfg.ServiceName),
+			str("service.version", version.Version),
+		}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "promptkit", Version: version.Version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// ParseHeaders parses the OTEL_EXPORTER_OTLP_HEADERS format "k1=v1,k2=v2".
+func ParseHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(k) != "" {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return headers
+}
+
+// The types below mirror the OTLP/JSON trace encoding.
+
+type request struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []Span `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Span is an OTLP span.
+type Span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes"`
+	Events            []Event    `json:"events,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+// Event is an OTLP span event.
+type Event struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []KeyValue `json:"attributes"`
+}
+
+// Status is an OTLP span status.
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// KeyValue is an OTLP attribute.
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value Value  `json:"value"`
+}
+
+// Value is an OTLP AnyValue; exactly one field is set.
+type Value struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *float64    `json:"doubleValue,omitempty"`
+	ArrayValue  *ArrayValue `json:"arrayValue,omitempty"`
+}
+
+// ArrayValue is an OTLP array attribute value.
+type ArrayValue struct {
+	Values []Value `json:"values"`
+}
+
+const (
+	spanKindClient = 3
+	statusError    = 2
+)
+
+func str(k, v string) KeyValue { return KeyValue{Key: k, Value: Value{StringValue: &v}} }
+
+func integer(k string, v int64) KeyValue {
+	s := strconv.FormatInt(v, 10)
+	return KeyValue{Key: k, Value: Value{IntValue: &s}}
+}
+
+func double(k string, v float64) KeyValue { return KeyValue{Key: k, Value: Value{DoubleValue: &v}} }
+
+func strs(k string, vs ...string) KeyValue {
+	arr := &ArrayValue{}
+	for _, v := range vs {
+		arr.Values = append(arr.Values, Value{StringValue: &v})
+	}
+	return KeyValue{Key: k, Value: Value{ArrayValue: arr}}
+}
+
+func nanos(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+
+// FromSession converts a recorded session into a span following the OpenTelemetry
+// GenAI semantic conventions. Sessions recorded without trace context get a
+// new trace.
+func FromSession(s session.Session, captureContent bool) Span {
+	md := s.Metadata
+	end := md.Timestamp
+	start := end.Add(-time.Duration(md.LatencyMS) * time.Millisecond)
+
+	op := "text_completion"
+	if len(s.Messages()) > 0 || strings.HasSuffix(s.Request.Path, "/chat/completions") {
+		op = "chat"
+	}
+	model := s.Model()
+
+	sp := Span{
+		TraceID:           md.TraceID,
+		SpanID:            md.SpanID,
+		ParentSpanID:      md.ParentSpanID,
+		Name:              strings.TrimSpace(op + " " + model),
+		Kind:              spanKindClient,
+		StartTimeUnixNano: nanos(start),
+		EndTimeUnixNano:   nanos(end),
+	}
+	if sp.TraceID == "" {
+		sp.TraceID = trace.NewTraceID()
+	}
+	if sp.SpanID == "" {
+		sp.SpanID = trace.NewSpanID()
+	}
+
+	attrs := []KeyValue{
+		str("gen_ai.operation.name", op),
+		str("gen_ai.system", "openai"),
+		str("promptkit.session.id", s.ID),
+	}
+	if model != "" {
+		attrs = append(attrs, str("gen_ai.request.model", model))
+	}
+	params := s.Params()
+	if v, ok := params["temperature"].(float64); ok {
+		attrs = append(attrs, double("gen_ai.request.temperature", v))
+	}
+	if v, ok := params["top_p"].(float64); ok {
+		attrs = append(attrs, double("gen_ai.request.top_p", v))
+	}
+	if v, ok := params["max_tokens"].(float64); ok {
+		attrs = append(attrs, integer("gen_ai.request.max_tokens", int64(v)))
+	}
+	if m := s.ResponseModel(); m != "" {
+		attrs = append(attrs, str("gen_ai.response.model", m))
+	}
+	if r := s.FinishReason(); r != "" {
+		attrs = append(attrs, strs("gen_ai.response.finish_reasons", r))
+	}
+	if u := s.Usage(); u.TotalTokens > 0 || u.PromptTokens > 0 {
+		attrs = append(attrs,
+			integer("gen_ai.usage.input_tokens", int64(u.PromptTokens)),
+			integer("gen_ai.usage.output_tokens", int64(u.CompletionTokens)))
+	}
+	if status := s.Response.Status; status != 0 {
+		attrs = append(attrs, integer("http.response.status_code", int64(status)))
+		if status >= 400 {
+			sp.Status = Status{Code: statusError, Message: http.StatusText(status)}
+			attrs = append(attrs, str("error.type", strconv.Itoa(status)))
+		}
+	}
+	sp.Attributes = attrs
+
+	if captureContent {
+		prompt := s.PromptText()
+		if msgs := s.Messages(); len(msgs) > 0 {
+			b, _ := json.Marshal(msgs)
+			prompt = string(b)
+		}
+		sp.Events = []Event{
+			{TimeUnixNano: nanos(start), Name: "gen_ai.content.prompt", Attributes: []KeyValue{str("gen_ai.prompt", prompt)}},
+			{TimeUnixNano: nanos(end), Name: "gen_ai.content.completion", Attributes: []KeyValue{str("gen_ai.completion", s.ResponseText())}},
+		}
+	}
+	return sp
+}
@@ -0,0 +1,75 @@
+package otlp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestExport(t *testing.T) {
+	received := make(chan request, 1)
+	var auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		received <- req
+	}))
+	defer collector.Close()
+
+	e := NewExporter(Config{Endpoint: collector.URL, Headers: ParseHeaders("Authorization=Bearer x"), CaptureContent: true})
+	e.Export(session.Session{
+		ID: "1",
+		Request: session.OpenAIRequest{Path: "/v1/chat/completions", Payload: map[string]any{
+			"model": "gpt-4o", "temperature": 0.5,
+			"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+		}},
+		Response: session.OpenAIResponse{Status: 200, Body: map[string]any{
+			"model":   "gpt-4o-2024",
+			"choices": []any{map[string]any{"message": map[string]any{"content": "hello"}, "finish_reason": "stop"}},
+			"usage":   map[string]any{"prompt_tokens": 3.0, "completion_tokens": 1.0, "total_tokens": 4.0},
+		}},
+		Metadata: session.Metadata{Timestamp: time.Now(), LatencyMS: 120, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+	})
+	e.Close()
+
+	var req request
+	select {
+	case req = <-received:
+	default:
+		t.Fatal("collector received nothing")
+	}
+	if auth != "Bearer x" {
+		t.Fatalf("headers not sent: %q", auth)
+	}
+	sp := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if sp.Name != "chat gpt-4o" || sp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sp.Kind != spanKindClient {
+		t.Fatalf("unexpected span %+v", sp)
+	}
+	attrs := map[string]Value{}
+	for _, kv := range sp.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["gen_ai.usage.input_tokens"]; v.IntValue == nil || *v.IntValue != "3" {
+		t.Fatalf("unexpected input tokens %+v", v)
+	}
+	if v := attrs["gen_ai.response.finish_reasons"]; v.ArrayValue == nil || *v.ArrayValue.Values[0].StringValue != "stop" {
+		t.Fatalf("unexpected finish reasons %+v", v)
+	}
+	if v := attrs["gen_ai.response.model"]; v.StringValue == nil || *v.StringValue != "gpt-4o-2024" {
+		t.Fatalf("unexpected response model %+v", v)
+	}
+	if len(sp.Events) != 2 || sp.Events[1].Name != "gen_ai.content.completion" {
+		t.Fatalf("expected content events, got %+v", sp.Events)
+	}
+}
@@ -0,0 +1,161 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/tokens"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// FileName is the default pricing table under the promptkit directory.
+const FileName = "pricing.yaml"
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Input       float64 `yaml:"input"`
+	Output      float64 `yaml:"output"`
+	CachedInput float64 `yaml:"cached_input"` // defaults to Input when zero
+}
+
+// Table maps model names to prices. Keys may be exact names, glob patterns
+// (gpt-4o-*) or prefixes matching dated variants (gpt-4o matches
+// gpt-4o-2024-08-06).
+type Table struct {
+	Models map[string]Price `yaml:"models"`
+}
+
+// Default returns a built-in table of common OpenAI models. Prices change;
+// override them with a pricing file.
+func Default() *Table {
+	return &Table{Models: map[string]Price{
+		"gpt-4o":        {Input: 2.50, Output: 10.00, CachedInput: 1.25},
+		"gpt-4o-mini":   {Input: 0.15, Output: 0.60, CachedInput: 0.075},
+		"gpt-4.1":       {Input: 2.00, Output: 8.00, CachedInput: 0.50},
+		"gpt-4.1-mini":  {Input: 0.40, Output: 1.60, CachedInput: 0.10},
+		"gpt-4.1-nano":  {Input: 0.10, Output: 0.40, CachedInput: 0.025},
+		"gpt-4-turbo":   {Input: 10.00, Output: 30.00},
+		"gpt-4":         {Input: 30.00, Output: 60.00},
+		"gpt-3.5-turbo": {Input: 0.50, Output: 1.50},
+		"o1":            {Input: 15.00, Output: 60.00, CachedInput: 7.50},
+		"o3-mini":       {Input: 1.10, Output: 4.40, CachedInput: 0.55},
+	}}
+}
+
+// Load reads a pricing table from a YAML file. Entries extend and override
+// the built-in defaults.
+func Load(file string) (*Table, error) {
+	t := Default()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var custom Table
+	if err := yaml.Unmarshal(b, &custom); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	for k, v := range custom.Models {
+		t.Models[k] = v
+	}
+	return t, nil
+}
+
+// LoadDefault loads FileName from the promptkit directory, falling back to
+// the built-in table when it does not exist.
+func LoadDefault() (*Table, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return nil, err
+	}
+	t, err := Load(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return Default(), nil
+	}
+	return t, err
+}
+
+// Lookup returns the price of model: an exact entry first, then the first
+// matching glob in name order, then the longest matching prefix.
+func (t *Table) Lookup(model string) (Price, bool) {
+	if p, ok := t.Models[model]; ok {
+		return p, true
+	}
+	keys := make([]string, 0, len(t.Models))
+	for k := range t.Models {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.ContainsAny(k, "*?[") {
+			if ok, _ := path.Match(k, model); ok {
+				return t.Models[k], true
+			}
+		}
+	}
+	best := ""
+	for _, k := range keys {
+		if strings.HasPrefix(model, k+"-") && len(k) > len(best) {
+			best = k
+		}
+	}
+	if best != "" {
+		return t.Models[best], true
+	}
+	return Price{}, false
+}
+
+// Cost returns the USD cost of the given usage.
+func (p Price) Cost(u session.TokenUsage) float64 {
+	cachedRate := p.CachedInput
+	if cachedRate == 0 {
+		cachedRate = p.Input
+	}
+	uncached := u.PromptTokens - u.CachedTokens
+	return (float64(uncached)*p.Input + float64(u.CachedTokens)*cachedRate + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// Estimate returns the cost of a request expected to use the given number of
+// tokens, all priced at the higher of the input and output rates so the
+// estimate errs on the high side. It returns 0 for models without a price.
+func (t *Table) Estimate(model string, tokens int) float64 {
+	if t == nil {
+		return 0
+	}
+	p, ok := t.Lookup(model)
+	if !ok {
+		return 0
+	}
+	return float64(tokens) * max(p.Input, p.Output) / 1e6
+}
+
+// Account fills in the token usage and cost of s. Usage reported by the
+// backend is used when present; otherwise tokens are estimated locally.
+// Error responses are not billed by backends and are left alone.
+func (t *Table) Account(s *session.Session) {
+	if t == nil || s.Response.Status >= 400 {
+		return
+	}
+	u := s.Usage()
+	usage := session.TokenUsage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		CachedTokens:     s.CachedTokens(),
+	}
+	if u.PromptTokens == 0 && u.CompletionTokens == 0 {
+		usage.PromptTokens = tokens.Prompt(*s)
+		usage.CompletionTokens = tokens.Completion(*s)
+		usage.Estimated = true
+	}
+	s.Metadata.Usage = &usage
+	if p, ok := t.Lookup(s.Model()); ok {
+		s.Metadata.CostUSD = p.Cost(usage)
+	}
+}
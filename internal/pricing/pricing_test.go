@@ -0,0 +1,97 @@
+package pricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestLookup(t *testing.T) {
+	tbl := &Table{Models: map[string]Price{
+		"gpt-4o":      {Input: 1},
+		"gpt-4o-mini": {Input: 2},
+		"claude-*":    {Input: 3},
+	}}
+	tests := map[string]float64{
+		"gpt-4o":                 1,
+		"gpt-4o-2024-08-06":      1,
+		"gpt-4o-mini-2024-07-18": 2,
+		"claude-3-opus":          3,
+	}
+	for model, want := range tests {
+		p, ok := tbl.Lookup(model)
+		if !ok || p.Input != want {
+			t.Errorf("Lookup(%q) = %v, %v; want input %v", model, p, ok, want)
+		}
+	}
+	if _, ok := tbl.Lookup("gpt-4"); ok {
+		t.Error("Lookup(gpt-4) matched, want no match")
+	}
+}
+
+func TestLoadOverridesDefaults(t *testing.T) {
+	file := filepath.Join(t.TempDir(), FileName)
+	os.WriteFile(file, []byte("models:\n  gpt-4o:\n    input: 5\n    output: 15\n  local-llama:\n    input: 0.01\n"), 0o644)
+	tbl, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := tbl.Lookup("gpt-4o"); p.Input != 5 || p.Output != 15 {
+		t.Errorf("gpt-4o = %+v, want overridden price", p)
+	}
+	if _, ok := tbl.Lookup("local-llama"); !ok {
+		t.Error("custom model missing")
+	}
+	if _, ok := tbl.Lookup("gpt-3.5-turbo"); !ok {
+		t.Error("default model missing")
+	}
+}
+
+func TestAccount(t *testing.T) {
+	tbl := &Table{Models: map[string]Price{"m": {Input: 1, Output: 2, CachedInput: 0.5}}}
+
+	s := session.Session{
+		Request: session.OpenAIRequest{Payload: map[string]any{"model": "m"}},
+		Response: session.OpenAIResponse{Body: map[string]any{"usage": map[string]any{
+			"prompt_tokens":         1000.0,
+			"completion_tokens":     500.0,
+			"prompt_tokens_details": map[string]any{"cached_tokens": 200.0},
+		}}},
+	}
+	tbl.Account(&s)
+	u := s.Metadata.Usage
+	if u == nil || u.PromptTokens != 1000 || u.CompletionTokens != 500 || u.CachedTokens != 200 || u.Estimated {
+		t.Fatalf("usage = %+v", u)
+	}
+	want := (800*1 + 200*0.5 + 500*2) / 1e6
+	if math.Abs(s.Metadata.CostUSD-want) > 1e-12 {
+		t.Errorf("cost = %v, want %v", s.Metadata.CostUSD, want)
+	}
+
+	streamed := session.Session{
+		Request: session.OpenAIRequest{Payload: map[string]any{
+			"model":    "m",
+			"messages": []any{map[string]any{"role": "user", "content": "hello there"}},
+		}},
+		Response: session.OpenAIResponse{Body: "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"},
+	}
+	tbl.Account(&streamed)
+	if u := streamed.Metadata.Usage; u == nil || !u.Estimated || u.PromptTokens == 0 || u.CompletionTokens != 1 {
+		t.Fatalf("estimated usage = %+v", u)
+	}
+	if streamed.Metadata.CostUSD == 0 {
+		t.Error("estimated session has no cost")
+	}
+
+	failed := session.Session{
+		Request:  streamed.Request,
+		Response: session.OpenAIResponse{Status: 429, Body: map[string]any{"error": map[string]any{"message": "slow down"}}},
+	}
+	tbl.Account(&failed)
+	if failed.Metadata.Usage != nil || failed.Metadata.CostUSD != 0 {
+		t.Errorf("failed session was accounted: usage %+v, cost %v", failed.Metadata.Usage, failed.Metadata.CostUSD)
+	}
+}
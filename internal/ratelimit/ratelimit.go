@@ -0,0 +1,281 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+	"github.com/promptkit/promptkit/internal/tokens"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// FileName is the default limits file under the promptkit directory.
+const FileName = "limits.yaml"
+
+// DefaultWaitTimeout is how long a request may queue when the config does
+// not say otherwise.
+const DefaultWaitTimeout = 30 * time.Second
+
+// ErrTimeout is returned when a request could not be admitted in time.
+var ErrTimeout = errors.New("timed out waiting for rate limit")
+
+// Rule limits requests per minute, tokens per minute and concurrent
+// requests. Zero values are unlimited.
+type Rule struct {
+	RPM         int `yaml:"rpm"`
+	TPM         int `yaml:"tpm"`
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+// Config is the limits file format. Backend rules are keyed by backend name;
+// model rules by model name or glob pattern.
+type Config struct {
+	WaitTimeout time.Duration   `yaml:"wait_timeout"`
+	Backends    map[string]Rule `yaml:"backends"`
+	Models      map[string]Rule `yaml:"models"`
+}
+
+// Load reads limits from a YAML file.
+func Load(file string) (*Limiter, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return New(cfg), nil
+}
+
+// LoadDefault loads FileName from the promptkit directory. It returns nil if
+// the file does not exist.
+func LoadDefault() (*Limiter, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return nil, err
+	}
+	l, err := Load(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return l, err
+}
+
+// bucket is a token bucket refilled continuously up to its capacity. A zero
+// capacity never limits.
+type bucket struct {
+	capacity float64
+	level    float64
+	perSec   float64
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) bucket {
+	c := float64(perMinute)
+	return bucket{capacity: c, level: c, perSec: c / 60, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b.capacity == 0 {
+		return
+	}
+	b.level = min(b.capacity, b.level+now.Sub(b.last).Seconds()*b.perSec)
+	b.last = now
+}
+
+// delay returns how long until n can be taken.
+func (b *bucket) delay(n float64) time.Duration {
+	if b.capacity == 0 {
+		return 0
+	}
+	n = min(n, b.capacity) // a request larger than the bucket waits for a full one
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.perSec * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	if b.capacity != 0 {
+		b.level -= min(n, b.capacity)
+	}
+}
+
+type state struct {
+	rule     Rule
+	requests bucket
+	tokens   bucket
+	inFlight int
+}
+
+func (s *state) full() bool {
+	return s.rule.MaxInFlight > 0 && s.inFlight >= s.rule.MaxInFlight
+}
+
+// Limiter admits requests according to backend and model rules, queueing
+// them until they fit.
+type Limiter struct {
+	cfg Config
+	now func() time.Time
+
+	mu      sync.Mutex
+	states  map[string]*state
+	changed chan struct{} // closed when a request finishes
+}
+
+// New returns a limiter for cfg.
+func New(cfg Config) *Limiter {
+	if cfg.WaitTimeout <= 0 {
+		cfg.WaitTimeout = DefaultWaitTimeout
+	}
+	return &Limiter{cfg: cfg, now: time.Now, states: map[string]*state{}, changed: make(chan struct{})}
+}
+
+func (l *Limiter) modelRule(model string) (string, Rule, bool) {
+	if r, ok := l.cfg.Models[model]; ok {
+		return model, r, true
+	}
+	for pattern, r := range l.cfg.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return pattern, r, true
+		}
+	}
+	return "", Rule{}, false
+}
+
+// statesFor returns the state of every rule that applies. l.mu must be held.
+func (l *Limiter) statesFor(backend, model string) []*state {
+	var out []*state
+	add := func(key string, r Rule) {
+		s := l.states[key]
+		if s == nil {
+			now := l.now()
+			s = &state{rule: r, requests: newBucket(r.RPM, now), tokens: newBucket(r.TPM, now)}
+			l.states[key] = s
+		}
+		out = append(out, s)
+	}
+	if r, ok := l.cfg.Backends[backend]; ok {
+		add("backend/"+backend, r)
+	}
+	if key, r, ok := l.modelRule(model); ok {
+		add("model/"+key, r)
+	}
+	return out
+}
+
+// Ticket is an admitted request. Done must be called when it finishes.
+type Ticket struct {
+	l      *Limiter
+	states []*state
+	tokens int
+	// Wait is how long the request was queued.
+	Wait time.Duration
+}
+
+// Acquire waits until a request to backend for model, estimated to use the
+// given number of tokens, fits within every applicable rule. It returns
+// ErrTimeout if it waited longer than the configured timeout. A nil *Limiter,
+// used when no limits are configured, admits every request at once with a
+// nil ticket.
+func (l *Limiter) Acquire(ctx context.Context, backend, model string, tokens int) (*Ticket, error) {
+	if l == nil {
+		return nil, nil
+	}
+	start := l.now()
+	ctx, cancel := context.WithTimeout(ctx, l.cfg.WaitTimeout)
+	defer cancel()
+
+	for {
+		l.mu.Lock()
+		states := l.statesFor(backend, model)
+		now := l.now()
+		var wait time.Duration
+		full := false
+		for _, s := range states {
+			s.requests.refill(now)
+			s.tokens.refill(now)
+			wait = max(wait, s.requests.delay(1), s.tokens.delay(float64(tokens)))
+			full = full || s.full()
+		}
+		if wait == 0 && !full {
+			for _, s := range states {
+				s.requests.take(1)
+				s.tokens.take(float64(tokens))
+				s.inFlight++
+			}
+			l.mu.Unlock()
+			return &Ticket{l: l, states: states, tokens: tokens, Wait: now.Sub(start)}, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		select {
+		case <-fire:
+		case <-changed:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, ErrTimeout
+			}
+			return nil, err
+		}
+	}
+}
+
+// Done releases the request's concurrency slot and corrects the token
+// estimate with the number of tokens actually used.
+func (t *Ticket) Done(used int) {
+	if t == nil {
+		return
+	}
+	l := t.l
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range t.states {
+		s.inFlight--
+		if s.tokens.capacity != 0 {
+			s.tokens.level = min(s.tokens.capacity, s.tokens.level+float64(t.tokens-used))
+		}
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Estimate returns the model of a JSON request body and the tokens it is
+// expected to use: the prompt plus the requested completion budget.
+func Estimate(body []byte) (model string, n int) {
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", 0
+	}
+	model, _ = payload["model"].(string)
+	n = tokens.Prompt(session.Session{Request: session.OpenAIRequest{Payload: payload}})
+	for _, k := range []string{"max_completion_tokens", "max_tokens"} {
+		if v, ok := payload[k].(float64); ok {
+			n += int(v)
+			break
+		}
+	}
+	return model, n
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	l := New(Config{Models: map[string]Rule{"gpt-*": {MaxInFlight: 1}}})
+	first, err := l.Acquire(context.Background(), "default", "gpt-4o", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan *Ticket)
+	go func() {
+		tk, err := l.Acquire(context.Background(), "default", "gpt-4o", 0)
+		if err != nil {
+			t.Error(err)
+		}
+		admitted <- tk
+	}()
+	select {
+	case <-admitted:
+		t.Fatal("second request admitted while the first is in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Done(0)
+	select {
+	case tk := <-admitted:
+		if tk.Wait < 50*time.Millisecond {
+			t.Errorf("wait = %v, want at least 50ms", tk.Wait)
+		}
+		tk.Done(0)
+	case <-time.After(time.Second):
+		t.Fatal("queued request not admitted")
+	}
+
+	if tk, _ := l.Acquire(context.Background(), "default", "other", 0); tk == nil || tk.Wait > 10*time.Millisecond {
+		t.Errorf("unlimited model was queued")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	l := New(Config{WaitTimeout: 20 * time.Millisecond, Backends: map[string]Rule{"default": {RPM: 1}}})
+	if _, err := l.Acquire(context.Background(), "default", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Acquire(context.Background(), "default", "", 0); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestTokenBudgetCorrection(t *testing.T) {
+	l := New(Config{Backends: map[string]Rule{"default": {TPM: 100}}})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	tk, _ := l.Acquire(context.Background(), "default", "", 90)
+	tk.Done(10) // overestimated by 80
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "default", "", 80); err != nil {
+		t.Fatalf("returned tokens not credited: %v", err)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	model, n := Estimate([]byte(`{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	if model != "gpt-4o" || n <= 100 {
+		t.Errorf("Estimate = %q, %d", model, n)
+	}
+	if _, n := Estimate([]byte("not json")); n != 0 {
+		t.Errorf("Estimate(invalid) = %d", n)
+	}
+}
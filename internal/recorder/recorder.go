@@ -1,41 +1,165 @@
 package recorder
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/crypt"
+	"github.com/promptkit/promptkit/internal/sessionfile"
 )
 
 // Recorder writes sessions to a JSON Lines file.
 type Recorder struct {
-	file *os.File
+	mu       sync.Mutex
+	file     *os.File
+	closed   bool
+	path     string
+	key      *crypt.Key
+	pathFor  func(time.Time) string
+	onRotate func(closed string)
+	now      func() time.Time
+}
+
+// Option configures a Recorder.
+type Option func(*Recorder)
+
+// WithKey encrypts every recorded line with key.
+func WithKey(key *crypt.Key) Option {
+	return func(r *Recorder) { r.key = key }
+}
+
+// OnRotate registers fn to be called with the path of a log file after a
+// daily recorder has closed it and moved on to the next one.
+func OnRotate(fn func(closed string)) Option {
+	return func(r *Recorder) { r.onRotate = fn }
 }
 
 // New creates a new Recorder writing to the given file path.
-func New(path string) (*Recorder, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
+func New(path string, opts ...Option) (*Recorder, error) {
+	r := &Recorder{path: path, now: time.Now}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.open(); err != nil {
 		return nil, err
 	}
-	return &Recorder{file: f}, nil
+	return r, nil
+}
+
+// NewDaily creates a Recorder that writes to one log per day in dir and
+// rotates to a new file when the day changes.
+func NewDaily(dir string, name func(time.Time) string, opts ...Option) (*Recorder, error) {
+	r := &Recorder{now: time.Now}
+	r.pathFor = func(t time.Time) string { return filepath.Join(dir, name(t)) }
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.path = r.pathFor(r.now())
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	r.file = f
+	return nil
+}
+
+// Path returns the file currently being written.
+func (r *Recorder) Path() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.path
 }
 
 // Close closes the underlying file.
 func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
 	if r.file == nil {
 		return nil
 	}
-	return r.file.Close()
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Pause holds off recording while fn runs and then reopens the current file,
+// so fn may rewrite or replace the logs without losing sessions recorded in
+// the meantime.
+func (r *Recorder) Pause(fn func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := fn()
+	if r.closed {
+		return err
+	}
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	if oerr := r.open(); oerr != nil {
+		if err == nil {
+			err = oerr
+		}
+	}
+	return err
 }
 
 // Record writes the given session object as JSON to the file.
 func (r *Recorder) Record(v interface{}) error {
-	if r.file == nil {
+	line, err := sessionfile.Encode(r.key, v)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
 		return fmt.Errorf("recorder closed")
 	}
-	enc := json.NewEncoder(r.file)
-	if err := enc.Encode(v); err != nil {
+	if err := r.rotate(); err != nil {
 		return err
 	}
-	return nil
+	if r.file == nil {
+		// An earlier rotation or reopen failed; try the file again.
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	_, err = r.file.Write(line)
+	return err
+}
+
+// rotate switches to the current day's file if it differs from the open one.
+// The caller must hold r.mu.
+func (r *Recorder) rotate() error {
+	if r.pathFor == nil {
+		return nil
+	}
+	next := r.pathFor(r.now())
+	if next == r.path {
+		return nil
+	}
+	closed := r.path
+	r.path = next
+	if r.file != nil {
+		err := r.file.Close()
+		r.file = nil
+		if err != nil {
+			return err
+		}
+		if r.onRotate != nil {
+			go r.onRotate(closed)
+		}
+	}
+	return r.open()
 }
@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDailyRotation(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2025, 7, 1, 23, 59, 0, 0, time.UTC)
+	name := func(t time.Time) string { return t.Format("2006-01-02") + ".jsonl" }
+
+	rotated := make(chan string, 1)
+	rec, err := NewDaily(dir, name, OnRotate(func(p string) { rotated <- p }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	rec.now = func() time.Time { return day }
+	rec.path = rec.pathFor(day)
+	rec.file.Close()
+	if err := rec.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Record(map[string]string{"id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	day = day.Add(2 * time.Minute)
+	if err := rec.Record(map[string]string{"id": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := filepath.Join(dir, "2025-07-01.jsonl")
+	select {
+	case p := <-rotated:
+		if p != first {
+			t.Fatalf("unexpected rotated path %s", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rotate hook not called")
+	}
+	if rec.Path() != filepath.Join(dir, "2025-07-02.jsonl") {
+		t.Fatalf("unexpected current path %s", rec.Path())
+	}
+	for _, p := range []string{first, rec.Path()} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 {
+			t.Fatalf("%s is empty", p)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.jsonl")
+	rec, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	rec.Record(map[string]string{"id": "1"})
+
+	// Replace the log the way sessionfile.Rewrite does.
+	err = rec.Pause(func() error {
+		if err := os.WriteFile(path+".tmp", []byte("{\"id\":\"2\"}\n"), 0o600); err != nil {
+			return err
+		}
+		return os.Rename(path+".tmp", path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(map[string]string{"id": "3"})
+
+	b, _ := os.ReadFile(path)
+	if string(b) != "{\"id\":\"2\"}\n{\"id\":\"3\"}\n" {
+		t.Errorf("log after replacing it = %q", b)
+	}
+}
+
+func TestRotateRetriesOpen(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	name := func(t time.Time) string { return t.Format("2006-01-02") + ".jsonl" }
+
+	rec, err := NewDaily(dir, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	rec.now = func() time.Time { return day }
+
+	// A directory in the way of the next day's log makes opening it fail.
+	next := filepath.Join(dir, "2025-07-02.jsonl")
+	if err := os.Mkdir(next, 0700); err != nil {
+		t.Fatal(err)
+	}
+	day = day.Add(24 * time.Hour)
+	if err := rec.Record(map[string]string{"id": "1"}); err == nil {
+		t.Fatal("expected an error opening the next log")
+	}
+	if err := rec.Record(map[string]string{"id": "2"}); err == nil {
+		t.Fatal("expected an error while the log cannot be opened")
+	}
+
+	if err := os.Remove(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Record(map[string]string{"id": "3"}); err != nil {
+		t.Fatalf("recorder did not recover: %v", err)
+	}
+	b, err := os.ReadFile(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"id":"3"}`+"\n" {
+		t.Fatalf("unexpected log contents %q", b)
+	}
+
+	rec.Close()
+	if err := rec.Record(map[string]string{"id": "4"}); err == nil {
+		t.Fatal("expected an error recording after Close")
+	}
+}
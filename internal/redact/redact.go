@@ -0,0 +1,80 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Mask replaces redacted text.
+const Mask = "[REDACTED]"
+
+// Redactor masks matches of its patterns in sessions before they are
+// recorded.
+type Redactor struct {
+	res []*regexp.Regexp
+}
+
+// New compiles patterns. It returns nil if there are none; a nil *Redactor
+// leaves strings and sessions untouched.
+func New(patterns []string) (*Redactor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("redact pattern %q: %w", p, err)
+		}
+		r.res = append(r.res, re)
+	}
+	return r, nil
+}
+
+// String masks every match in s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.res {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// Session masks matches in the prompt, request and response of s. Metadata
+// is left alone.
+func (r *Redactor) Session(s *session.Session) {
+	if r == nil {
+		return
+	}
+	s.SourcePrompt = r.String(s.SourcePrompt)
+	for i := range s.Request.Messages {
+		s.Request.Messages[i].Content = r.String(s.Request.Messages[i].Content)
+	}
+	s.Request.Prompt = r.value(s.Request.Prompt)
+	s.Request.Payload = r.value(s.Request.Payload)
+	for i := range s.Response.Choices {
+		s.Response.Choices[i].Message.Content = r.String(s.Response.Choices[i].Message.Content)
+	}
+	s.Response.Body = r.value(s.Response.Body)
+}
+
+// value masks every string in a decoded JSON value.
+func (r *Redactor) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case map[string]any:
+		for k, e := range v {
+			v[k] = r.value(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = r.value(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,41 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestSession(t *testing.T) {
+	r, err := New([]string{`sk-[A-Za-z0-9]+`, `\b\d{3}-\d{2}-\d{4}\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := session.Session{
+		Request: session.OpenAIRequest{Payload: map[string]any{
+			"model": "gpt-4o",
+			"messages": []any{
+				map[string]any{"role": "user", "content": "my key is sk-abc123 and SSN 123-45-6789"},
+			},
+		}},
+		Response: session.OpenAIResponse{Body: "data: {\"content\":\"sk-xyz\"}\n\n"},
+	}
+	r.Session(&s)
+
+	msgs := s.Messages()
+	if got, want := msgs[0].Content, "my key is [REDACTED] and SSN [REDACTED]"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if s.Model() != "gpt-4o" {
+		t.Errorf("model = %q", s.Model())
+	}
+	if got := s.Response.Body; got != "data: {\"content\":\"[REDACTED]\"}\n\n" {
+		t.Errorf("body = %q", got)
+	}
+
+	var none *Redactor
+	none.Session(&s)
+	if _, err := New([]string{"("}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
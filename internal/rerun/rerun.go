@@ -0,0 +1,93 @@
+package rerun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Options controls how a recorded session is re-sent.
+type Options struct {
+	Backend string // backend base URL
+	Model   string // overrides the recorded model when set
+	APIKey  string // sent as a bearer token when set
+	Client  *http.Client
+}
+
+// Request reconstructs the endpoint path and JSON body of a recorded session,
+// replacing the model when model is non-empty.
+func Request(s session.Session, model string) (string, []byte, error) {
+	payload := map[string]any{}
+	for k, v := range s.RequestPayload() {
+		payload[k] = v
+	}
+	if model != "" {
+		payload["model"] = model
+	}
+
+	path := s.Request.Path
+	if path == "" {
+		path = "/v1/completions"
+		if _, ok := payload["messages"]; ok {
+			path = "/v1/chat/completions"
+		}
+	}
+	body, err := json.Marshal(payload)
+	return path, body, err
+}
+
+// Run re-sends the request of s to the configured backend and returns the
+// new session, linked to s through its parent ID.
+func Run(ctx context.Context, s session.Session, opts Options) (session.Session, error) {
+	base, err := url.Parse(opts.Backend)
+	if err != nil {
+		return session.Session{}, fmt.Errorf("backend: %w", err)
+	}
+	path, body, err := Request(s, opts.Model)
+	if err != nil {
+		return session.Session{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base.ResolveReference(&url.URL{Path: path}).String(), bytes.NewReader(body))
+	if err != nil {
+		return session.Session{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return session.Session{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return session.Session{}, err
+	}
+
+	out, err := session.FromExchange(http.MethodPost, path, body, resp.StatusCode, respBody, start)
+	if err != nil {
+		return session.Session{}, err
+	}
+	out.Origin = session.OriginRerun
+	out.Metadata.ParentID = s.ID
+	out.Metadata.Tags = s.Metadata.Tags
+	if hash, err := session.ComputeHash(out); err == nil {
+		out.Metadata.SessionHash = hash
+	}
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package rerun
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestRun(t *testing.T) {
+	var got map[string]any
+	var gotPath, gotAuth string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"hello"}}]}`)
+	}))
+	defer backend.Close()
+
+	orig := session.Session{
+		ID: "orig",
+		Request: session.OpenAIRequest{
+			Path: "/v1/chat/completions",
+			Payload: map[string]any{
+				"model":    "gpt-4",
+				"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+			},
+		},
+	}
+
+	out, err := Run(context.Background(), orig, Options{Backend: backend.URL, Model: "gpt-4o", APIKey: "sk-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/v1/chat/completions" || gotAuth != "Bearer sk-test" {
+		t.Fatalf("unexpected request %s %q", gotPath, gotAuth)
+	}
+	if got["model"] != "gpt-4o" {
+		t.Fatalf("model not overridden: %v", got["model"])
+	}
+	if out.Metadata.ParentID != "orig" || out.Origin != session.OriginRerun {
+		t.Fatalf("new session not linked: %+v", out.Metadata)
+	}
+	if out.ResponseText() != "hello" {
+		t.Fatalf("unexpected response %q", out.ResponseText())
+	}
+	if orig.Model() != "gpt-4" {
+		t.Fatalf("original payload was modified")
+	}
+}
+
+func TestRequestStructured(t *testing.T) {
+	s := session.Session{Request: session.OpenAIRequest{Model: "m", Prompt: "say hi"}}
+	path, body, err := Request(s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/completions" {
+		t.Fatalf("unexpected path %s", path)
+	}
+	if string(body) != `{"model":"m","prompt":"say hi"}` {
+		t.Fatalf("unexpected body %s", body)
+	}
+}
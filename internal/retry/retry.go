@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default backoff bounds.
+const (
+	DefaultBaseDelay = 500 * time.Millisecond
+	DefaultMaxDelay  = 30 * time.Second
+)
+
+// Policy decides whether and when to retry a failed upstream call. The zero
+// Policy never retries.
+type Policy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the backoff before the second attempt; it doubles with
+	// each further attempt up to MaxDelay. Delays are fully jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// Enabled reports whether p allows more than one attempt.
+func (p Policy) Enabled() bool { return p.MaxAttempts > 1 }
+
+// statelessPaths are POST endpoints that only compute a result, so sending
+// the same request twice has no side effects beyond cost.
+var statelessPaths = map[string]bool{
+	"/v1/completions":      true,
+	"/v1/chat/completions": true,
+	"/v1/embeddings":       true,
+	"/v1/moderations":      true,
+}
+
+// Safe reports whether a request may be sent more than once: safe HTTP
+// methods, stateless inference endpoints and requests carrying an
+// Idempotency-Key.
+func Safe(method, path string, h http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost:
+		return statelessPaths[path] || h.Get("Idempotency-Key") != ""
+	}
+	return false
+}
+
+// Retryable reports whether an attempt that ended with status, or with a
+// transport error if status is 0, is worth repeating. Plain 500s are not
+// retried since the backend may have done the work.
+func Retryable(status int) bool {
+	switch status {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Delay returns how long to wait before attempt n (2 for the first retry).
+// A Retry-After (or Retry-After-Ms) header on the failed response takes
+// precedence over the backoff. ok is false if the server asked for a longer
+// wait than MaxDelay, in which case the failure should be returned as is.
+func (p Policy) Delay(n int, h http.Header) (d time.Duration, ok bool) {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+	if d, found := RetryAfter(h, time.Now()); found {
+		return d, d <= maxDelay
+	}
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	backoff := base << (n - 2)
+	if backoff <= 0 || backoff > maxDelay {
+		backoff = maxDelay
+	}
+	return rand.N(backoff) + 1, true
+}
+
+// RetryAfter parses the Retry-After-Ms header or the Retry-After header in
+// either its seconds or HTTP-date form.
+func RetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	if h == nil {
+		return 0, false
+	}
+	if v := h.Get("Retry-After-Ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,58 @@
+package retry
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSafe(t *testing.T) {
+	tests := []struct {
+		method, path string
+		key          string
+		want         bool
+	}{
+		{http.MethodGet, "/v1/models", "", true},
+		{http.MethodPost, "/v1/chat/completions", "", true},
+		{http.MethodPost, "/v1/files", "", false},
+		{http.MethodPost, "/v1/files", "abc", true},
+		{http.MethodDelete, "/v1/files/1", "", false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.key != "" {
+			h.Set("Idempotency-Key", tt.key)
+		}
+		if got := Safe(tt.method, tt.path, h); got != tt.want {
+			t.Errorf("Safe(%s %s, key %q) = %v, want %v", tt.method, tt.path, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n := 2; n <= 10; n++ {
+		d, ok := p.Delay(n, nil)
+		limit := min(100*time.Millisecond<<(n-2), time.Second)
+		if !ok || d <= 0 || d > limit {
+			t.Errorf("Delay(%d) = %v, %v; want (0, %v]", n, d, ok, limit)
+		}
+	}
+
+	h := http.Header{"Retry-After": {"2"}}
+	if d, ok := p.Delay(2, h); ok || d != 2*time.Second {
+		t.Errorf("Delay with Retry-After beyond MaxDelay = %v, %v; want 2s, false", d, ok)
+	}
+	h = http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}
+	if d, ok := p.Delay(2, h); !ok || d != 250*time.Millisecond {
+		t.Errorf("Delay with Retry-After-Ms = %v, %v; want 250ms", d, ok)
+	}
+}
+
+func TestRetryAfterDate(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := http.Header{"Retry-After": {now.Add(3 * time.Second).Format(http.TimeFormat)}}
+	if d, ok := RetryAfter(h, now); !ok || d != 3*time.Second {
+		t.Errorf("RetryAfter = %v, %v; want 3s", d, ok)
+	}
+}
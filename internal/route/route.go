@@ -0,0 +1,187 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/appdir"
+)
+
+// FileName is the default routing file under the promptkit directory.
+const FileName = "routes.yaml"
+
+// Default names the backend given with start --backend.
+const Default = "default"
+
+// Backend is an upstream API.
+type Backend struct {
+	Name string `yaml:"-"`
+	// URL is the base URL; the request path is appended to its path.
+	URL string `yaml:"url"`
+	// StripPrefix is removed from the request path first, e.g. "/v1" for
+	// Azure deployment URLs.
+	StripPrefix string `yaml:"strip_prefix"`
+	// Query parameters added to every request, e.g. api-version.
+	Query map[string]string `yaml:"query"`
+	// Headers set on every request. Values may reference environment
+	// variables as ${VAR}.
+	Headers map[string]string `yaml:"headers"`
+	// ForwardAuth passes the client's Authorization and Api-Key headers on
+	// to the backend. Only the Default backend receives them otherwise, so
+	// a key meant for one provider is not sent to another.
+	ForwardAuth bool `yaml:"forward_auth"`
+
+	base *url.URL
+}
+
+// Header returns the headers of a request to b: h without the client's
+// credentials unless b may receive them, with b's Headers set.
+func (b *Backend) Header(h http.Header) http.Header {
+	out := h.Clone()
+	if b.Name != Default && !b.ForwardAuth {
+		out.Del("Authorization")
+		out.Del("Api-Key")
+	}
+	for k, v := range b.Headers {
+		out.Set(k, v)
+	}
+	return out
+}
+
+// Target returns the upstream URL for a request URL.
+func (b *Backend) Target(u *url.URL) string {
+	t := *b.base
+	t.Path = strings.TrimSuffix(b.base.Path, "/") + strings.TrimPrefix(u.Path, b.StripPrefix)
+	t.RawPath = ""
+	q := u.Query()
+	for k, v := range b.Query {
+		q.Set(k, v)
+	}
+	t.RawQuery = q.Encode()
+	return t.String()
+}
+
+// Rule routes requests whose model matches Model (a name or glob pattern)
+// and whose path starts with Path to Backends, tried in order. Empty
+// criteria match everything.
+type Rule struct {
+	Model    string   `yaml:"model"`
+	Path     string   `yaml:"path"`
+	Backends []string `yaml:"backends"`
+}
+
+func (r Rule) matches(model, p string) bool {
+	if r.Path != "" && !strings.HasPrefix(p, r.Path) {
+		return false
+	}
+	if r.Model != "" {
+		ok, _ := path.Match(r.Model, model)
+		return ok
+	}
+	return true
+}
+
+// Config is the routing file format.
+type Config struct {
+	Backends map[string]*Backend `yaml:"backends"`
+	Routes   []Rule              `yaml:"routes"`
+}
+
+// Table resolves requests to an ordered list of backends.
+type Table struct {
+	backends map[string]*Backend
+	routes   []Rule
+}
+
+// New builds a routing table. defaultURL is registered as the Default
+// backend unless cfg defines one.
+func New(cfg Config, defaultURL string) (*Table, error) {
+	t := &Table{backends: map[string]*Backend{}, routes: cfg.Routes}
+	if defaultURL != "" {
+		t.backends[Default] = &Backend{URL: defaultURL}
+	}
+	for name, b := range cfg.Backends {
+		t.backends[name] = b
+	}
+	for name, b := range t.backends {
+		if b == nil || b.URL == "" {
+			return nil, fmt.Errorf("backend %s: url required", name)
+		}
+		u, err := url.Parse(b.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("backend %s: invalid url %q", name, b.URL)
+		}
+		b.Name = name
+		b.base = u
+		for k, v := range b.Headers {
+			b.Headers[k] = os.ExpandEnv(v)
+		}
+	}
+	for i, r := range t.routes {
+		if len(r.Backends) == 0 {
+			return nil, fmt.Errorf("route %d: no backends", i+1)
+		}
+		for _, name := range r.Backends {
+			if t.backends[name] == nil {
+				return nil, fmt.Errorf("route %d: unknown backend %q", i+1, name)
+			}
+		}
+	}
+	if len(t.backends) == 0 {
+		return nil, errors.New("no backends configured")
+	}
+	return t, nil
+}
+
+// Load reads a routing file.
+func Load(file, defaultURL string) (*Table, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return New(cfg, defaultURL)
+}
+
+// LoadDefault loads FileName from the promptkit directory, or routes
+// everything to defaultURL if it does not exist.
+func LoadDefault(defaultURL string) (*Table, error) {
+	dir, err := appdir.PromptkitDir()
+	if err != nil {
+		return nil, err
+	}
+	t, err := Load(filepath.Join(dir, FileName), defaultURL)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(Config{}, defaultURL)
+	}
+	return t, err
+}
+
+// Resolve returns the backends for a request, primary first. Requests that
+// match no rule go to the Default backend.
+func (t *Table) Resolve(model, path string) []*Backend {
+	for _, r := range t.routes {
+		if r.matches(model, path) {
+			out := make([]*Backend, len(r.Backends))
+			for i, name := range r.Backends {
+				out[i] = t.backends[name]
+			}
+			return out
+		}
+	}
+	if b := t.backends[Default]; b != nil {
+		return []*Backend{b}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tbl, err := New(Config{
+		Backends: map[string]*Backend{
+			"azure": {URL: "https://res.openai.azure.com/openai/deployments/gpt4o"},
+			"local": {URL: "http://localhost:8000"},
+		},
+		Routes: []Rule{
+			{Path: "/v1/embeddings", Backends: []string{"local"}},
+			{Model: "gpt-4o*", Backends: []string{Default, "azure"}},
+		},
+	}, "https://api.openai.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(bs []*Backend) []string {
+		var out []string
+		for _, b := range bs {
+			out = append(out, b.Name)
+		}
+		return out
+	}
+	tests := []struct {
+		model, path string
+		want        []string
+	}{
+		{"gpt-4o-mini", "/v1/chat/completions", []string{Default, "azure"}},
+		{"gpt-4o", "/v1/embeddings", []string{"local"}},
+		{"llama", "/v1/chat/completions", []string{Default}},
+	}
+	for _, tt := range tests {
+		got := names(tbl.Resolve(tt.model, tt.path))
+		if len(got) != len(tt.want) {
+			t.Errorf("Resolve(%q, %q) = %v, want %v", tt.model, tt.path, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Resolve(%q, %q) = %v, want %v", tt.model, tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(Config{Routes: []Rule{{Model: "x", Backends: []string{"missing"}}}}, "https://api.openai.com")
+	if err == nil {
+		t.Error("unknown backend accepted")
+	}
+	if _, err := New(Config{}, "not a url"); err == nil {
+		t.Error("invalid url accepted")
+	}
+	if _, err := New(Config{Backends: map[string]*Backend{"foo": nil}}, ""); err == nil {
+		t.Error("backend without url accepted")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	t.Setenv("AZURE_KEY", "secret")
+	tbl, err := New(Config{Backends: map[string]*Backend{"azure": {
+		URL:         "https://res.openai.azure.com/openai/deployments/gpt4o",
+		StripPrefix: "/v1",
+		Query:       map[string]string{"api-version": "2024-06-01"},
+		Headers:     map[string]string{"api-key": "${AZURE_KEY}"},
+	}}}, "https://api.openai.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("/v1/chat/completions")
+	az := tbl.backends["azure"]
+	if got, want := az.Target(u), "https://res.openai.azure.com/openai/deployments/gpt4o/chat/completions?api-version=2024-06-01"; got != want {
+		t.Errorf("Target = %s, want %s", got, want)
+	}
+	if az.Headers["api-key"] != "secret" {
+		t.Errorf("header not expanded: %v", az.Headers)
+	}
+	if got, want := tbl.backends[Default].Target(u), "https://api.openai.com/v1/chat/completions"; got != want {
+		t.Errorf("Target = %s, want %s", got, want)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	tbl, err := New(Config{Backends: map[string]*Backend{
+		"azure": {URL: "https://azure.example", Headers: map[string]string{"Api-Key": "az"}},
+		"proxy": {URL: "https://proxy.example", ForwardAuth: true},
+	}}, "https://api.openai.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := http.Header{"Authorization": {"Bearer sk-client"}, "Content-Type": {"application/json"}}
+	tests := map[string]struct{ auth, apiKey string }{
+		Default: {"Bearer sk-client", ""},
+		"azure": {"", "az"},
+		"proxy": {"Bearer sk-client", ""},
+	}
+	for name, want := range tests {
+		h := tbl.backends[name].Header(in)
+		if h.Get("Authorization") != want.auth || h.Get("Api-Key") != want.apiKey || h.Get("Content-Type") == "" {
+			t.Errorf("%s: headers %v", name, h)
+		}
+	}
+	if in.Get("Authorization") == "" {
+		t.Error("Header modified its argument")
+	}
+}
@@ -0,0 +1,366 @@
+package sessionfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/promptkit/promptkit/internal/crypt"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// maxLine bounds the size of a single session line. Full response bodies can
+// easily exceed bufio.Scanner's 64KB default.
+const maxLine = 64 << 20
+
+// CompressedExt is appended to session logs compressed after rotation.
+const CompressedExt = ".gz"
+
+// Files returns all session log files in dir, sorted by name. Compressed logs
+// are included; if a log exists both plain and compressed (a compression in
+// progress) only the plain file is returned.
+func Files(dir string) ([]string, error) {
+	plain, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(filepath.Join(dir, "*.jsonl"+CompressedExt))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(plain))
+	for _, f := range plain {
+		seen[f] = true
+	}
+	files := plain
+	for _, f := range compressed {
+		if !seen[strings.TrimSuffix(f, CompressedExt)] {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// IsLog reports whether name looks like a plain or compressed session log.
+func IsLog(name string) bool {
+	return strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl"+CompressedExt)
+}
+
+// Open opens a session log for reading, decompressing it if needed.
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, CompressedExt) {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &gzipFile{Reader: zr, f: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// Compress gzips the log at path into path+CompressedExt and removes the
+// original once the compressed copy is safely on disk.
+func Compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := path + CompressedExt
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Decoder decodes session lines, decrypting them when needed. Without
+// explicit keys the default key is loaded lazily the first time an encrypted
+// line is seen.
+type Decoder struct {
+	keys   []*crypt.Key
+	loaded bool
+	err    error
+}
+
+// NewDecoder returns a Decoder using keys, or the default key if none are
+// given. Nil keys are ignored.
+func NewDecoder(keys ...*crypt.Key) *Decoder {
+	d := &Decoder{}
+	for _, k := range keys {
+		if k != nil {
+			d.keys = append(d.keys, k)
+		}
+	}
+	d.loaded = len(d.keys) > 0
+	return d
+}
+
+// Decode parses a single line into a session.
+func (d *Decoder) Decode(line []byte) (session.Session, error) {
+	var s session.Session
+	plain, err := d.Plain(line)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(plain, &s)
+	return s, err
+}
+
+// Plain returns the JSON text of line, decrypting it if needed.
+func (d *Decoder) Plain(line []byte) ([]byte, error) {
+	if !crypt.IsEncrypted(line) {
+		return line, nil
+	}
+	return d.open(line)
+}
+
+func (d *Decoder) open(line []byte) ([]byte, error) {
+	k, err := d.keyFor(line)
+	if err != nil {
+		return nil, err
+	}
+	return k.Open(line)
+}
+
+// keyFor returns the key an encrypted line was sealed with, falling back to
+// the first key for lines that do not name one.
+func (d *Decoder) keyFor(line []byte) (*crypt.Key, error) {
+	if !d.loaded {
+		var k *crypt.Key
+		k, d.err = crypt.LoadDefault()
+		if k != nil {
+			d.keys = append(d.keys, k)
+		}
+		d.loaded = true
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.keys) == 0 {
+		return nil, crypt.ErrNoKey
+	}
+	id := crypt.LineKeyID(line)
+	for _, k := range d.keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return d.keys[0], nil
+}
+
+// LineFunc is called for every line in a file. err is non-nil if the line
+// could not be decoded. Returning false stops the scan.
+type LineFunc func(s session.Session, err error) bool
+
+// Scan reads every session line in path and calls fn for each of them.
+func (d *Decoder) Scan(path string, fn LineFunc) error {
+	f, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if !fn(d.Decode(sc.Bytes())) {
+			return nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// Encode marshals v as a single line terminated by a newline, sealing it with
+// key when key is non-nil.
+func Encode(key *crypt.Key, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		b = key.Seal(b)
+	}
+	return append(b, '\n'), nil
+}
+
+// Rekey rewrites path so every line is encrypted with key, decrypting existing
+// lines with d. Plaintext lines are encrypted as well. Compressed logs stay
+// compressed. The file is replaced atomically.
+func Rekey(path string, d *Decoder, key *crypt.Key) error {
+	return rewrite(path, ".rekey", func(line []byte) ([]byte, error) {
+		plain, err := d.Plain(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return key.Seal(plain), nil
+	})
+}
+
+// Edit is what an EditFunc does with a session.
+type Edit int
+
+const (
+	Keep   Edit = iota // leave the line as it is
+	Update             // write back the modified session
+	Drop               // remove the session
+)
+
+// EditFunc is called for every session of a log being rewritten and may
+// modify it in place.
+type EditFunc func(s *session.Session) Edit
+
+// Rewrite lets fn update or drop the sessions in path and returns how many it
+// changed. Updated sessions stay encrypted with the key that sealed them and
+// lines that cannot be decoded are kept as they are. The file is replaced
+// atomically, and only if something changed.
+func Rewrite(path string, d *Decoder, fn EditFunc) (int, error) {
+	changed := 0
+	err := rewrite(path, ".edit", func(line []byte) ([]byte, error) {
+		s, err := d.Decode(line)
+		if err != nil {
+			return line, nil
+		}
+		switch fn(&s) {
+		case Drop:
+			changed++
+			return nil, nil
+		case Update:
+			changed++
+			var key *crypt.Key
+			if crypt.IsEncrypted(line) {
+				if key, err = d.keyFor(line); err != nil {
+					return nil, err
+				}
+			}
+			b, err := Encode(key, s)
+			if err != nil {
+				return nil, err
+			}
+			return b[:len(b)-1], nil
+		}
+		return line, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// rewrite copies path to a temporary file with the given suffix, passing
+// every non-empty line through fn, which returns the line to write or nil to
+// drop it. Compressed logs stay compressed. The copy atomically replaces path
+// if any line was changed or dropped.
+func rewrite(path, suffix string, fn func(line []byte) ([]byte, error)) error {
+	in, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + suffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	var zw *gzip.Writer
+	w := bufio.NewWriter(out)
+	if strings.HasSuffix(path, CompressedExt) {
+		zw = gzip.NewWriter(out)
+		w = bufio.NewWriter(zw)
+	}
+	changed := false
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 0, 64*1024), maxLine)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		line, err := fn(sc.Bytes())
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if !bytes.Equal(line, sc.Bytes()) {
+			changed = true
+		}
+		if line != nil {
+			w.Write(line)
+			w.WriteByte('\n')
+		}
+	}
+	if err := sc.Err(); err != nil {
+		out.Close()
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,146 @@
+package sessionfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/promptkit/promptkit/internal/crypt"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.jsonl")
+	oldKey, _ := crypt.Generate("")
+	newKey, _ := crypt.Generate("")
+
+	plain, _ := Encode(nil, session.Session{ID: "1"})
+	sealed, _ := Encode(oldKey, session.Session{ID: "2"})
+	if err := os.WriteFile(path, append(plain, sealed...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rekey(path, NewDecoder(oldKey), newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	err := NewDecoder(newKey).Scan(path, func(s session.Session, err error) bool {
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		ids = append(ids, s.ID)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Fatalf("unexpected sessions %v", ids)
+	}
+
+	err = NewDecoder(oldKey).Scan(path, func(s session.Session, err error) bool {
+		if err == nil {
+			t.Fatalf("old key should no longer decrypt")
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chat-2025-07-01.jsonl")
+	line, _ := Encode(nil, session.Session{ID: "1"})
+	if err := os.WriteFile(path, line, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Compress(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected plain log removed, got %v", err)
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != path+CompressedExt {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	var ids []string
+	NewDecoder().Scan(files[0], func(s session.Session, err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.ID)
+		return true
+	})
+	if len(ids) != 1 || ids[0] != "1" {
+		t.Fatalf("unexpected sessions %v", ids)
+	}
+
+	// A plain file shadows its compressed copy while compression is underway.
+	os.WriteFile(path, line, 0o600)
+	files, _ = Files(dir)
+	if len(files) != 1 || files[0] != path {
+		t.Fatalf("expected plain file to shadow compressed copy, got %v", files)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.jsonl")
+	key, _ := crypt.Generate("")
+	var data []byte
+	for _, id := range []string{"1", "2"} {
+		line, _ := Encode(nil, session.Session{ID: id})
+		data = append(data, line...)
+	}
+	sealed, _ := Encode(key, session.Session{ID: "3"})
+	data = append(data, sealed...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(key)
+	n, err := Rewrite(path, d, func(s *session.Session) Edit {
+		switch s.ID {
+		case "1":
+			return Drop
+		case "3":
+			s.Metadata.Notes = "checked"
+			return Update
+		}
+		return Keep
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Rewrite = %d, %v", n, err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !crypt.IsEncrypted([]byte(lines[1])) {
+		t.Fatalf("rewritten log:\n%s", b)
+	}
+	var got []session.Session
+	d.Scan(path, func(s session.Session, err error) bool {
+		got = append(got, s)
+		return true
+	})
+	if len(got) != 2 || got[0].ID != "2" || got[1].Metadata.Notes != "checked" {
+		t.Errorf("sessions = %+v", got)
+	}
+
+	// Nothing changed, so the file is left alone.
+	info, _ := os.Stat(path)
+	if n, err := Rewrite(path, d, func(*session.Session) Edit { return Keep }); err != nil || n != 0 {
+		t.Fatalf("Rewrite = %d, %v", n, err)
+	}
+	if after, _ := os.Stat(path); !os.SameFile(info, after) {
+		t.Error("unchanged log was replaced")
+	}
+}
@@ -0,0 +1,196 @@
+package stats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Dimensions sessions can be grouped by. The empty dimension aggregates
+// everything into a single "all" group.
+var Dimensions = []string{"model", "tag", "origin", "backend", "day", "hour"}
+
+// Group holds aggregates for the sessions sharing one key. Rejected requests
+// and cache hits never reached a backend; they are counted separately and
+// left out of the error rate and latency percentiles.
+type Group struct {
+	Key              string  `json:"key"`
+	Count            int     `json:"count"`
+	Rejected         int     `json:"rejected"`
+	CacheHits        int     `json:"cache_hits"`
+	Errors           int     `json:"errors"`
+	ErrorRate        float64 `json:"error_rate"`
+	P50MS            int64   `json:"p50_ms"`
+	P95MS            int64   `json:"p95_ms"`
+	P99MS            int64   `json:"p99_ms"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Tokens           int     `json:"tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+
+	latencies []int64
+}
+
+// Compute aggregates sessions grouped by the given dimension. A session with
+// several tags counts towards each of them.
+func Compute(sessions []session.Session, by string) ([]Group, error) {
+	keyFn, err := keyFunc(by)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string]*Group{}
+	for _, s := range sessions {
+		for _, k := range keyFn(s) {
+			g := groups[k]
+			if g == nil {
+				g = &Group{Key: k}
+				groups[k] = g
+			}
+			g.add(s)
+		}
+	}
+
+	out := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		g.finish()
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if by == "day" || by == "hour" {
+			return out[i].Key < out[j].Key
+		}
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out, nil
+}
+
+func keyFunc(by string) (func(session.Session) []string, error) {
+	switch by {
+	case "":
+		return func(session.Session) []string { return []string{"all"} }, nil
+	case "model":
+		return func(s session.Session) []string { return []string{orNone(s.Model())} }, nil
+	case "origin":
+		return func(s session.Session) []string { return []string{orNone(string(s.Origin))} }, nil
+	case "backend":
+		return func(s session.Session) []string { return []string{orNone(s.Metadata.Backend)} }, nil
+	case "tag":
+		return func(s session.Session) []string {
+			if len(s.Metadata.Tags) == 0 {
+				return []string{"(none)"}
+			}
+			return s.Metadata.Tags
+		}, nil
+	case "day":
+		return func(s session.Session) []string {
+			return []string{s.Metadata.Timestamp.Local().Format("2006-01-02")}
+		}, nil
+	case "hour":
+		return func(s session.Session) []string {
+			return []string{s.Metadata.Timestamp.Local().Format("2006-01-02 15:00")}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown group %q (want one of %v)", by, Dimensions)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+func (g *Group) add(s session.Session) {
+	g.Count++
+	switch {
+	case s.Metadata.Status == session.StatusRejected:
+		g.Rejected++
+	case s.Metadata.Cache != nil && s.Metadata.Cache.Status == session.CacheHit:
+		g.CacheHits++
+	default:
+		if s.Response.Status >= 400 {
+			g.Errors++
+		}
+		g.latencies = append(g.latencies, s.Metadata.LatencyMS)
+	}
+	if u := s.Metadata.Usage; u != nil {
+		g.PromptTokens += u.PromptTokens
+		g.CompletionTokens += u.CompletionTokens
+	} else {
+		u := s.Usage()
+		g.PromptTokens += u.PromptTokens
+		g.CompletionTokens += u.CompletionTokens
+	}
+	g.CostUSD += s.Metadata.CostUSD
+}
+
+func (g *Group) finish() {
+	g.Tokens = g.PromptTokens + g.CompletionTokens
+	if n := g.Count - g.Rejected - g.CacheHits; n > 0 {
+		g.ErrorRate = float64(g.Errors) / float64(n)
+	}
+	sort.Slice(g.latencies, func(i, j int) bool { return g.latencies[i] < g.latencies[j] })
+	g.P50MS = percentile(g.latencies, 50)
+	g.P95MS = percentile(g.latencies, 95)
+	g.P99MS = percentile(g.latencies, 99)
+	g.latencies = nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// PrintTable writes groups as an aligned table.
+func PrintTable(w io.Writer, groups []Group) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Key\tCount\tRejected\tCached\tErrors\tp50\tp95\tp99\tTokens\tCost")
+	fmt.Fprintln(tw, "---\t-----\t--------\t------\t------\t---\t---\t---\t------\t----")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d (%.1f%%)\t%dms\t%dms\t%dms\t%d\t%s\n",
+			g.Key, g.Count, g.Rejected, g.CacheHits, g.Errors, g.ErrorRate*100, g.P50MS, g.P95MS, g.P99MS, g.Tokens, list.FormatCost(g.CostUSD))
+	}
+	tw.Flush()
+}
+
+// WriteCSV writes groups as CSV with a header row.
+func WriteCSV(w io.Writer, groups []Group) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"key", "count", "errors", "error_rate", "p50_ms", "p95_ms", "p99_ms", "prompt_tokens", "completion_tokens", "tokens", "cost_usd", "rejected", "cache_hits"})
+	for _, g := range groups {
+		cw.Write([]string{
+			g.Key,
+			strconv.Itoa(g.Count),
+			strconv.Itoa(g.Errors),
+			strconv.FormatFloat(g.ErrorRate, 'f', 4, 64),
+			strconv.FormatInt(g.P50MS, 10),
+			strconv.FormatInt(g.P95MS, 10),
+			strconv.FormatInt(g.P99MS, 10),
+			strconv.Itoa(g.PromptTokens),
+			strconv.Itoa(g.CompletionTokens),
+			strconv.Itoa(g.Tokens),
+			strconv.FormatFloat(g.CostUSD, 'f', 6, 64),
+			strconv.Itoa(g.Rejected),
+			strconv.Itoa(g.CacheHits),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
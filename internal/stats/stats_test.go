@@ -0,0 +1,104 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func sess(model string, latency int64, status int, tags ...string) session.Session {
+	return session.Session{
+		Origin:   session.OriginProxy,
+		Request:  session.OpenAIRequest{Model: model},
+		Response: session.OpenAIResponse{Status: status},
+		Metadata: session.Metadata{
+			Timestamp: time.Date(2025, 3, 1, 10, 30, 0, 0, time.Local),
+			LatencyMS: latency,
+			Tags:      tags,
+			Usage:     &session.TokenUsage{PromptTokens: 10, CompletionTokens: 5},
+			CostUSD:   0.5,
+		},
+	}
+}
+
+func TestComputeByModel(t *testing.T) {
+	var sessions []session.Session
+	for i := int64(1); i <= 100; i++ {
+		sessions = append(sessions, sess("a", i, 200))
+	}
+	sessions = append(sessions, sess("b", 7, 500), sess("b", 9, 200))
+
+	groups, err := Compute(sessions, "model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Key != "a" || groups[1].Key != "b" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	a := groups[0]
+	if a.Count != 100 || a.P50MS != 50 || a.P95MS != 95 || a.P99MS != 99 {
+		t.Errorf("a = %+v", a)
+	}
+	if a.Tokens != 1500 || a.CostUSD != 50 {
+		t.Errorf("a totals = %d tokens, $%v", a.Tokens, a.CostUSD)
+	}
+	b := groups[1]
+	if b.Errors != 1 || b.ErrorRate != 0.5 || b.P50MS != 7 || b.P99MS != 9 {
+		t.Errorf("b = %+v", b)
+	}
+}
+
+func TestComputeAnsweredByDaemon(t *testing.T) {
+	rejected := sess("a", 0, 429)
+	rejected.Metadata.Status = session.StatusRejected
+	hit := sess("a", 1, 200)
+	hit.Metadata.Cache = &session.CacheInfo{Status: session.CacheHit}
+	groups, err := Compute([]session.Session{sess("a", 40, 500), sess("a", 60, 200), rejected, hit}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := groups[0]
+	if g.Count != 4 || g.Rejected != 1 || g.CacheHits != 1 || g.Errors != 1 || g.ErrorRate != 0.5 {
+		t.Errorf("counts = %+v", g)
+	}
+	if g.P50MS != 40 || g.P99MS != 60 {
+		t.Errorf("percentiles include daemon answers: %+v", g)
+	}
+}
+
+func TestComputeByTag(t *testing.T) {
+	groups, err := Compute([]session.Session{
+		sess("a", 1, 200, "x", "y"),
+		sess("a", 1, 200, "x"),
+		sess("a", 1, 200),
+	}, "tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, g := range groups {
+		got[g.Key] = g.Count
+	}
+	if got["x"] != 2 || got["y"] != 1 || got["(none)"] != 1 {
+		t.Errorf("counts = %v", got)
+	}
+
+	if _, err := Compute(nil, "week"); err == nil {
+		t.Error("unknown group accepted")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	groups, _ := Compute([]session.Session{sess("a", 3, 200)}, "hour")
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, groups); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "2025-03-01 10:00,1,0,") {
+		t.Errorf("csv = %q", buf.String())
+	}
+}
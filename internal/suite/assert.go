@@ -0,0 +1,142 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Assertion types.
+const (
+	AssertExact       = "exact"
+	AssertContains    = "contains"
+	AssertRegex       = "regex"
+	AssertJSONSchema  = "json_schema"
+	AssertMaxLatency  = "max_latency_ms"
+	AssertMaxTokens   = "max_tokens"
+	AssertSimilarity  = "similarity"
+	defaultSimilarity = 0.8
+)
+
+// Assertion is a check on the output of a re-run session.
+type Assertion struct {
+	Type string `yaml:"type"`
+	// Value is the expected text for exact, contains and regex, or the limit
+	// for max_latency_ms and max_tokens. An empty exact value compares with
+	// the recorded response.
+	Value string `yaml:"value"`
+	// Schema is an inline JSON schema for json_schema.
+	Schema map[string]any `yaml:"schema"`
+	// Min is the minimum similarity score in [0,1] for similarity.
+	Min float64 `yaml:"min"`
+
+	re     *regexp.Regexp
+	schema *jsonschema.Schema
+	limit  float64
+}
+
+func (a *Assertion) compile() error {
+	var err error
+	switch a.Type {
+	case AssertExact, AssertContains:
+	case AssertRegex:
+		a.re, err = regexp.Compile(a.Value)
+	case AssertJSONSchema:
+		var b []byte
+		if b, err = json.Marshal(a.Schema); err == nil {
+			a.schema, err = jsonschema.CompileString("schema.json", string(b))
+		}
+	case AssertMaxLatency, AssertMaxTokens:
+		_, err = fmt.Sscan(a.Value, &a.limit)
+	case AssertSimilarity:
+		if a.Min == 0 {
+			a.Min = defaultSimilarity
+		}
+	default:
+		err = fmt.Errorf("unknown assertion type %q", a.Type)
+	}
+	return err
+}
+
+// Check returns a failure message, or "" if the assertion holds for out.
+// orig is the recorded session out was re-run from.
+func (a Assertion) Check(orig, out session.Session) string {
+	text := out.ResponseText()
+	switch a.Type {
+	case AssertExact:
+		want := a.Value
+		if want == "" {
+			want = orig.ResponseText()
+		}
+		if text != want {
+			return fmt.Sprintf("exact: got %q, want %q", text, want)
+		}
+	case AssertContains:
+		if !strings.Contains(text, a.Value) {
+			return fmt.Sprintf("contains: %q not found", a.Value)
+		}
+	case AssertRegex:
+		if !a.re.MatchString(text) {
+			return fmt.Sprintf("regex: %q did not match", a.Value)
+		}
+	case AssertJSONSchema:
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return fmt.Sprintf("json_schema: response is not JSON: %v", err)
+		}
+		if err := a.schema.Validate(v); err != nil {
+			return fmt.Sprintf("json_schema: %v", err)
+		}
+	case AssertMaxLatency:
+		if float64(out.Metadata.LatencyMS) > a.limit {
+			return fmt.Sprintf("max_latency_ms: took %dms, limit %s", out.Metadata.LatencyMS, a.Value)
+		}
+	case AssertMaxTokens:
+		if tokens := list.Summarize(out).Tokens; float64(tokens) > a.limit {
+			return fmt.Sprintf("max_tokens: used %d, limit %s", tokens, a.Value)
+		}
+	case AssertSimilarity:
+		if score := Similarity(text, orig.ResponseText()); score < a.Min {
+			return fmt.Sprintf("similarity: %.2f below %.2f", score, a.Min)
+		}
+	}
+	return ""
+}
+
+var wordRe = regexp.MustCompile(`\w+`)
+
+// Similarity returns the cosine similarity of the word-frequency vectors of
+// a and b, from 0 (nothing in common) to 1 (same words, same proportions).
+func Similarity(a, b string) float64 {
+	fa, fb := wordFreq(a), wordFreq(b)
+	if len(fa) == 0 && len(fb) == 0 {
+		return 1
+	}
+	var dot, na, nb float64
+	for w, x := range fa {
+		dot += x * fb[w]
+		na += x * x
+	}
+	for _, y := range fb {
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func wordFreq(s string) map[string]float64 {
+	freq := map[string]float64{}
+	for _, w := range wordRe.FindAllString(strings.ToLower(s), -1) {
+		freq[w]++
+	}
+	return freq
+}
@@ -0,0 +1,79 @@
+package suite
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as a JUnit XML report.
+func WriteJUnit(w io.Writer, name string, results []Result) error {
+	js := junitSuite{Name: name, Tests: len(results)}
+	var total time.Duration
+	for _, r := range results {
+		total += r.Duration
+		tc := junitCase{Name: r.Case, Classname: name, Time: seconds(r.Duration)}
+		if r.Session != "" {
+			tc.Name = r.Case + "/" + r.Session
+		}
+		switch {
+		case r.Err != nil:
+			js.Errors++
+			tc.Error = &junitMessage{Message: r.Err.Error(), Body: r.Err.Error()}
+		case len(r.Failures) > 0:
+			js.Failures++
+			tc.Failure = &junitMessage{Message: r.Failures[0], Body: strings.Join(r.Failures, "\n")}
+		}
+		js.Cases = append(js.Cases, tc)
+	}
+	js.Time = seconds(total)
+
+	doc := junitSuites{Tests: js.Tests, Failures: js.Failures, Errors: js.Errors, Time: js.Time, Suites: []junitSuite{js}}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
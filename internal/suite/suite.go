@@ -0,0 +1,163 @@
+package suite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/rerun"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Suite is a prompt regression suite loaded from YAML.
+type Suite struct {
+	Name    string `yaml:"name"`
+	Backend string `yaml:"backend"`
+	Model   string `yaml:"model"`
+	// Assertions apply to every case in addition to the case's own.
+	Assertions []Assertion `yaml:"assertions"`
+	Cases      []Case      `yaml:"cases"`
+}
+
+// Case selects recorded sessions to re-run and the assertions to check on
+// their new outputs. Exactly one of Session, Tag or Filter should be set.
+type Case struct {
+	Name       string      `yaml:"name"`
+	Session    string      `yaml:"session"`
+	Tag        string      `yaml:"tag"`
+	Filter     string      `yaml:"filter"`
+	Limit      int         `yaml:"limit"`
+	Assertions []Assertion `yaml:"assertions"`
+}
+
+// Result is the outcome of running one recorded session.
+type Result struct {
+	Case     string
+	Session  string
+	Duration time.Duration
+	Failures []string
+	Err      error
+	Output   *session.Session
+}
+
+// Passed reports whether the run succeeded and all assertions held.
+func (r Result) Passed() bool { return r.Err == nil && len(r.Failures) == 0 }
+
+// Load reads a suite from path and compiles its assertions.
+func Load(path string) (*Suite, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Suite
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if s.Name == "" {
+		s.Name = path
+	}
+	for i := range s.Assertions {
+		if err := s.Assertions[i].compile(); err != nil {
+			return nil, fmt.Errorf("suite assertion %d: %w", i+1, err)
+		}
+	}
+	for i := range s.Cases {
+		c := &s.Cases[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case-%d", i+1)
+		}
+		for j := range c.Assertions {
+			if err := c.Assertions[j].compile(); err != nil {
+				return nil, fmt.Errorf("%s assertion %d: %w", c.Name, j+1, err)
+			}
+		}
+	}
+	return &s, nil
+}
+
+// Select returns the recorded sessions matched by c.
+func (c Case) Select(sessions []session.Session) ([]session.Session, error) {
+	pred, err := list.ParseFilter(c.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Name, err)
+	}
+	var out []session.Session
+	for _, s := range sessions {
+		if c.Session != "" && s.ID != c.Session {
+			continue
+		}
+		if c.Tag != "" && !hasTag(s, c.Tag) {
+			continue
+		}
+		if !pred(list.ToMap(s)) {
+			continue
+		}
+		out = append(out, s)
+		if c.Limit > 0 && len(out) == c.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func hasTag(s session.Session, tag string) bool {
+	for _, t := range s.Metadata.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Run re-runs every selected session with opts and checks the assertions.
+// Cases that match no sessions produce a failing result.
+func (s *Suite) Run(ctx context.Context, sessions []session.Session, opts rerun.Options) ([]Result, error) {
+	if opts.Backend == "" {
+		opts.Backend = s.Backend
+	}
+	if opts.Model == "" {
+		opts.Model = s.Model
+	}
+	if opts.Backend == "" {
+		return nil, fmt.Errorf("no backend configured")
+	}
+
+	var results []Result
+	for _, c := range s.Cases {
+		selected, err := c.Select(sessions)
+		if err != nil {
+			return nil, err
+		}
+		if len(selected) == 0 {
+			results = append(results, Result{Case: c.Name, Failures: []string{"no sessions matched"}})
+			continue
+		}
+		asserts := append(append([]Assertion{}, s.Assertions...), c.Assertions...)
+		for _, orig := range selected {
+			res := Result{Case: c.Name, Session: orig.ID}
+			start := time.Now()
+			out, err := rerun.Run(ctx, orig, opts)
+			res.Duration = time.Since(start)
+			if err != nil {
+				res.Err = err
+				results = append(results, res)
+				continue
+			}
+			res.Output = &out
+			if out.Response.Status >= 400 {
+				res.Failures = append(res.Failures, fmt.Sprintf("backend returned status %d", out.Response.Status))
+			}
+			for _, a := range asserts {
+				if msg := a.Check(orig, out); msg != "" {
+					res.Failures = append(res.Failures, msg)
+				}
+			}
+			results = append(results, res)
+		}
+	}
+	return results, nil
+}
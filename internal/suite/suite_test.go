@@ -0,0 +1,99 @@
+package suite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/promptkit/promptkit/internal/rerun"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+const suiteYAML = `
+name: smoke
+assertions:
+  - type: max_latency_ms
+    value: "5000"
+cases:
+  - name: greeting
+    tag: qa
+    assertions:
+      - type: contains
+        value: hello
+      - type: similarity
+        min: 0.5
+  - name: json
+    session: "2"
+    assertions:
+      - type: json_schema
+        schema:
+          type: object
+          required: [answer]
+  - name: nothing
+    filter: request.model=missing
+`
+
+func TestRun(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"hello world"}}],"usage":{"total_tokens":3}}`)
+	}))
+	defer backend.Close()
+
+	path := filepath.Join(t.TempDir(), "suite.yaml")
+	os.WriteFile(path, []byte(suiteYAML), 0o644)
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := func(id string, tags ...string) session.Session {
+		return session.Session{
+			ID:       id,
+			Request:  session.OpenAIRequest{Path: "/v1/chat/completions", Payload: map[string]any{"model": "gpt"}},
+			Response: session.OpenAIResponse{Body: map[string]any{"choices": []any{map[string]any{"message": map[string]any{"content": "hello there world"}}}}},
+			Metadata: session.Metadata{Tags: tags},
+		}
+	}
+	sessions := []session.Session{recorded("1", "qa"), recorded("2")}
+
+	results, err := s.Run(context.Background(), sessions, rerun.Options{Backend: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if !results[0].Passed() {
+		t.Fatalf("greeting should pass: %v %v", results[0].Failures, results[0].Err)
+	}
+	if results[1].Passed() || !strings.HasPrefix(results[1].Failures[0], "json_schema") {
+		t.Fatalf("json case should fail schema: %v", results[1].Failures)
+	}
+	if results[2].Passed() {
+		t.Fatalf("empty case should fail")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, s.Name, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<testsuites tests="3" failures="2" errors="0"`) {
+		t.Fatalf("unexpected junit report:\n%s", buf.String())
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if got := Similarity("The cat sat", "the cat sat"); got < 0.999 {
+		t.Fatalf("expected identical texts to score 1, got %f", got)
+	}
+	if got := Similarity("alpha beta", "gamma delta"); got != 0 {
+		t.Fatalf("expected disjoint texts to score 0, got %f", got)
+	}
+}
@@ -0,0 +1,197 @@
+package thread
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+type ref struct {
+	threadID string
+	turn     int
+}
+
+// Index assigns thread IDs to chat sessions. A session joins a thread when
+// its message history extends the messages (and reply) of an earlier
+// session; otherwise it starts a new thread named after its own ID. Older
+// logs hold IDs with one-second resolution, so a thread whose name is
+// already in use gets a random suffix instead of merging into the other.
+type Index struct {
+	mu       sync.Mutex
+	byPrefix map[[32]byte]ref
+	threads  map[string]bool
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{byPrefix: make(map[[32]byte]ref), threads: make(map[string]bool)}
+}
+
+// Seed registers previously recorded sessions, assigning threads in memory to
+// those recorded without one. Sessions should be in chronological order.
+func (ix *Index) Seed(sessions []session.Session) {
+	for i := range sessions {
+		s := sessions[i]
+		if s.Metadata.ThreadID == "" {
+			ix.Assign(&s)
+			continue
+		}
+		ix.mu.Lock()
+		ix.register(s)
+		ix.mu.Unlock()
+	}
+}
+
+// Assign sets the thread ID and turn of s and registers it so later turns of
+// the same conversation can find it. Sessions without chat messages are left
+// untouched.
+func (ix *Index) Assign(s *session.Session) {
+	msgs := s.Messages()
+	if len(msgs) == 0 {
+		return
+	}
+	chain := prefixHashes(msgs)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	s.Metadata.ThreadID, s.Metadata.Turn = "", 1
+	for k := len(msgs) - 1; k > 0; k-- {
+		if r, ok := ix.byPrefix[chain[k-1]]; ok {
+			s.Metadata.ThreadID, s.Metadata.Turn = r.threadID, r.turn+1
+			break
+		}
+	}
+	if s.Metadata.ThreadID == "" {
+		s.Metadata.ThreadID = s.ID
+		for s.Metadata.ThreadID == "" || ix.threads[s.Metadata.ThreadID] {
+			suffix := make([]byte, 4)
+			rand.Read(suffix)
+			s.Metadata.ThreadID = s.ID + "-" + hex.EncodeToString(suffix)
+		}
+	}
+	ix.register(*s)
+}
+
+// register indexes the request messages of s with and without its reply.
+// The caller must hold ix.mu.
+func (ix *Index) register(s session.Session) {
+	msgs := s.Messages()
+	if len(msgs) == 0 {
+		return
+	}
+	r := ref{threadID: s.Metadata.ThreadID, turn: s.Metadata.Turn}
+	ix.threads[r.threadID] = true
+	full := append(msgs[:len(msgs):len(msgs)], Reply(s))
+	chain := prefixHashes(full)
+	ix.byPrefix[chain[len(msgs)-1]] = r
+	ix.byPrefix[chain[len(msgs)]] = r
+}
+
+// prefixHashes returns a chained hash for every prefix of msgs; element k-1
+// identifies msgs[:k].
+func prefixHashes(msgs []session.Message) [][32]byte {
+	out := make([][32]byte, len(msgs))
+	var prev [32]byte
+	for i, m := range msgs {
+		h := sha256.New()
+		h.Write(prev[:])
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+		copy(prev[:], h.Sum(nil))
+		out[i] = prev
+	}
+	return out
+}
+
+// Reply returns the assistant message produced by s.
+func Reply(s session.Session) session.Message {
+	return session.Message{Role: "assistant", Content: s.ResponseText()}
+}
+
+// Turn is one exchange in a thread: the messages first sent in that session
+// followed by the assistant reply.
+type Turn struct {
+	Session  string            `json:"session"`
+	Turn     int               `json:"turn"`
+	Messages []session.Message `json:"messages"`
+}
+
+// Thread is a multi-turn conversation reconstructed from its sessions.
+type Thread struct {
+	ID    string `json:"id"`
+	Turns []Turn `json:"turns"`
+}
+
+// Find returns the thread containing the session or thread with the given
+// ID, or nil if there is none.
+func Find(sessions []session.Session, id string) *Thread {
+	threadID := ""
+	for _, s := range sessions {
+		if tid := threadOf(s); tid != "" && (tid == id || s.ID == id) {
+			threadID = tid
+			break
+		}
+	}
+	if threadID == "" {
+		return nil
+	}
+	var members []session.Session
+	for _, s := range sessions {
+		if threadOf(s) == threadID {
+			members = append(members, s)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Metadata.Turn != members[j].Metadata.Turn {
+			return members[i].Metadata.Turn < members[j].Metadata.Turn
+		}
+		return members[i].Metadata.Timestamp.Before(members[j].Metadata.Timestamp)
+	})
+
+	t := &Thread{ID: threadID}
+	seen := 0
+	for _, s := range members {
+		msgs := s.Messages()
+		if seen > len(msgs) {
+			seen = 0 // a branch that rewrote history; show it in full
+		}
+		turn := Turn{Session: s.ID, Turn: max(s.Metadata.Turn, 1)}
+		turn.Messages = append(turn.Messages, msgs[seen:]...)
+		turn.Messages = append(turn.Messages, Reply(s))
+		t.Turns = append(t.Turns, turn)
+		seen = len(msgs) + 1
+	}
+	return t
+}
+
+// threadOf returns the thread of s. Chat sessions recorded before threading
+// was introduced are the first turn of a thread named after themselves.
+func threadOf(s session.Session) string {
+	if s.Metadata.ThreadID != "" {
+		return s.Metadata.ThreadID
+	}
+	if len(s.Messages()) > 0 {
+		return s.ID
+	}
+	return ""
+}
+
+// Format renders the conversation as text, each message shown once.
+func Format(t *Thread) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Thread %s (%d turns)\n", t.ID, len(t.Turns))
+	for _, turn := range t.Turns {
+		fmt.Fprintf(&b, "\n── turn %d · %s ──\n", turn.Turn, turn.Session)
+		for _, m := range turn.Messages {
+			fmt.Fprintf(&b, "%s: %s\n", m.Role, m.Content)
+		}
+	}
+	return b.String()
+}
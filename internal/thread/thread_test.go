@@ -0,0 +1,84 @@
+package thread
+
+import (
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func chat(id, reply string, msgs ...string) session.Session {
+	var m []session.Message
+	for i, c := range msgs {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		m = append(m, session.Message{Role: role, Content: c})
+	}
+	return session.Session{
+		ID:       id,
+		Request:  session.OpenAIRequest{Messages: m},
+		Response: session.OpenAIResponse{Choices: []session.Choice{{Message: session.Message{Role: "assistant", Content: reply}}}},
+	}
+}
+
+func TestAssign(t *testing.T) {
+	ix := NewIndex()
+	first := chat("1", "hello", "hi")
+	ix.Assign(&first)
+	second := chat("2", "fine", "hi", "hello", "how are you?")
+	ix.Assign(&second)
+	other := chat("3", "hello", "hi")
+	ix.Assign(&other)
+	third := chat("4", "bye", "hi", "hello", "how are you?", "fine", "bye")
+	ix.Assign(&third)
+
+	if first.Metadata.ThreadID != "1" || first.Metadata.Turn != 1 {
+		t.Fatalf("first turn: %+v", first.Metadata)
+	}
+	if second.Metadata.ThreadID != "1" || second.Metadata.Turn != 2 {
+		t.Fatalf("second turn: %+v", second.Metadata)
+	}
+	if other.Metadata.ThreadID != "3" {
+		t.Fatalf("identical opener should start its own thread: %+v", other.Metadata)
+	}
+	if third.Metadata.ThreadID != "1" || third.Metadata.Turn != 3 {
+		t.Fatalf("third turn: %+v", third.Metadata)
+	}
+
+	th := Find([]session.Session{third, other, second, first}, "2")
+	if th == nil || th.ID != "1" || len(th.Turns) != 3 {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+	if got := th.Turns[1].Messages; len(got) != 2 || got[0].Content != "how are you?" || got[1].Content != "fine" {
+		t.Fatalf("second turn should only hold new messages: %+v", got)
+	}
+}
+
+func TestSeedLegacy(t *testing.T) {
+	ix := NewIndex()
+	ix.Seed([]session.Session{chat("old", "hello", "hi")})
+	next := chat("new", "ok", "hi", "hello", "again")
+	ix.Assign(&next)
+	if next.Metadata.ThreadID != "old" || next.Metadata.Turn != 2 {
+		t.Fatalf("expected to continue legacy session: %+v", next.Metadata)
+	}
+	old := chat("old", "hello", "hi")
+	if th := Find([]session.Session{next, old}, "old"); th == nil || len(th.Turns) != 2 {
+		t.Fatalf("unexpected thread %+v", th)
+	}
+}
+
+func TestAssignDuplicateID(t *testing.T) {
+	ix := NewIndex()
+	a := chat("20240101120000", "hello", "hi")
+	ix.Assign(&a)
+	b := chat("20240101120000", "4", "2+2?")
+	ix.Assign(&b)
+	if a.Metadata.ThreadID == b.Metadata.ThreadID {
+		t.Fatalf("unrelated sessions with the same ID share thread %q", a.Metadata.ThreadID)
+	}
+	if b.Metadata.Turn != 1 {
+		t.Fatalf("expected a new thread: %+v", b.Metadata)
+	}
+}
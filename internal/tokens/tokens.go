@@ -0,0 +1,58 @@
+package tokens
+
+import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// pieceRe splits text the way GPT-style BPE tokenizers pre-tokenize it:
+// contractions, words with their leading space, short digit runs,
+// punctuation runs and whitespace.
+var pieceRe = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)| ?\pL+| ?\pN{1,3}| ?[^\s\pL\pN]+|\s+`)
+
+// Per-message framing overhead of the chat format, from OpenAI's guidance.
+const (
+	perMessage = 3
+	perName    = 1
+	perReply   = 3
+)
+
+// Count estimates the number of tokens in text. Common words are a single
+// token; longer pieces are split roughly every four characters. It is a rough
+// stand-in for a real tokenizer, good enough for cost estimates.
+func Count(text string) int {
+	n := 0
+	for _, piece := range pieceRe.FindAllString(text, -1) {
+		l := utf8.RuneCountInString(piece)
+		switch {
+		case l <= 6:
+			n++
+		default:
+			n += (l + 3) / 4
+		}
+	}
+	return n
+}
+
+// Prompt estimates the prompt tokens of a request.
+func Prompt(s session.Session) int {
+	msgs := s.Messages()
+	if len(msgs) == 0 {
+		return Count(s.PromptText())
+	}
+	n := perReply
+	for _, m := range msgs {
+		n += perMessage + Count(m.Role) + Count(m.Content)
+		if m.Name != "" {
+			n += perName + Count(m.Name)
+		}
+	}
+	return n
+}
+
+// Completion estimates the completion tokens of a response.
+func Completion(s session.Session) int {
+	return Count(s.ResponseText())
+}
@@ -0,0 +1,53 @@
+package tokens
+
+import (
+	"testing"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 1},
+		{"Hello, world!", 4},
+		{"don't", 2},
+		{"12345", 2},
+		{"internationalization", 5},
+		{"func main() {}", 4},
+	}
+	for _, tt := range tests {
+		if got := Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	chat := func(msgs ...any) session.Session {
+		return session.Session{Request: session.OpenAIRequest{Payload: map[string]any{"messages": msgs}}}
+	}
+	tests := []struct {
+		name string
+		s    session.Session
+		want int
+	}{
+		{"empty", session.Session{}, 0},
+		{"completion", session.Session{Request: session.OpenAIRequest{Payload: map[string]any{"prompt": "hello world"}}}, 2},
+		{"chat", chat(
+			map[string]any{"role": "system", "content": "You are terse."},
+			map[string]any{"role": "user", "content": "Hi"},
+		), 16},
+		{"named", chat(
+			map[string]any{"role": "user", "content": "Hi", "name": "bob"},
+		), 10},
+	}
+	for _, tt := range tests {
+		if got := Prompt(tt.s); got != tt.want {
+			t.Errorf("%s: Prompt = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+// Header is the W3C trace context header name.
+const Header = "traceparent"
+
+var traceparentRe = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// Context is a parsed W3C traceparent.
+type Context struct {
+	TraceID string
+	SpanID  string
+	Flags   string
+}
+
+// Parse parses a traceparent header value. All-zero IDs and version ff are
+// rejected as required by the spec.
+func Parse(header string) (Context, bool) {
+	m := traceparentRe.FindStringSubmatch(strings.TrimSpace(strings.ToLower(header)))
+	if m == nil || m[1] == "ff" {
+		return Context{}, false
+	}
+	if strings.Trim(m[2], "0") == "" || strings.Trim(m[3], "0") == "" {
+		return Context{}, false
+	}
+	return Context{TraceID: m[2], SpanID: m[3], Flags: m[4]}, true
+}
+
+// String formats c as a traceparent header value.
+func (c Context) String() string {
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, c.SpanID, c.Flags)
+}
+
+// NewSpanID returns a random 8-byte span ID in hex.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+// NewTraceID returns a random 16-byte trace ID in hex.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Span is one recorded LLM call within a trace, or a caller span that
+// recorded calls point to as their parent.
+type Span struct {
+	SpanID    string    `json:"span_id"`
+	SessionID string    `json:"session_id,omitempty"` // empty for caller spans
+	Model     string    `json:"model,omitempty"`
+	Start     time.Time `json:"start"`
+	LatencyMS int64     `json:"latency_ms"`
+	Tokens    int       `json:"tokens"`
+	// GapMS is the time between the end of the previous sibling call and the
+	// start of this one, typically spent executing tools.
+	GapMS    int64   `json:"gap_ms,omitempty"`
+	Children []*Span `json:"children,omitempty"`
+}
+
+// Tree is the call graph of a trace.
+type Tree struct {
+	TraceID    string  `json:"trace_id"`
+	Calls      int     `json:"calls"`
+	Tokens     int     `json:"tokens"`
+	DurationMS int64   `json:"duration_ms"`
+	Roots      []*Span `json:"roots"`
+}
+
+// Build reconstructs the call graph of traceID from recorded sessions, or
+// returns nil if no session belongs to it.
+func Build(sessions []session.Session, traceID string) *Tree {
+	spans := map[string]*Span{}
+	parents := map[string]string{}
+	var order []string
+	tree := &Tree{TraceID: traceID}
+	var first, last time.Time
+
+	for _, s := range sessions {
+		if s.Metadata.TraceID != traceID {
+			continue
+		}
+		start := s.Metadata.Timestamp.Add(-time.Duration(s.Metadata.LatencyMS) * time.Millisecond)
+		sp := &Span{
+			SpanID:    s.Metadata.SpanID,
+			SessionID: s.ID,
+			Model:     s.Model(),
+			Start:     start,
+			LatencyMS: s.Metadata.LatencyMS,
+			Tokens:    list.Summarize(s).Tokens,
+		}
+		spans[sp.SpanID] = sp
+		parents[sp.SpanID] = s.Metadata.ParentSpanID
+		order = append(order, sp.SpanID)
+
+		tree.Calls++
+		tree.Tokens += sp.Tokens
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if end := s.Metadata.Timestamp; end.After(last) {
+			last = end
+		}
+	}
+	if tree.Calls == 0 {
+		return nil
+	}
+	tree.DurationMS = last.Sub(first).Milliseconds()
+
+	// Calls whose parent span was not itself recorded hang off a caller span
+	// representing the client's own work.
+	for _, id := range order {
+		sp := spans[id]
+		pid := parents[id]
+		parent, ok := spans[pid]
+		if !ok {
+			if pid == "" {
+				tree.Roots = append(tree.Roots, sp)
+				continue
+			}
+			parent = &Span{SpanID: pid, Start: sp.Start}
+			spans[pid] = parent
+			tree.Roots = append(tree.Roots, parent)
+		}
+		parent.Children = append(parent.Children, sp)
+	}
+
+	for _, root := range tree.Roots {
+		finish(root)
+	}
+	sortSpans(tree.Roots)
+	return tree
+}
+
+// finish orders children by start time, computes the gaps between siblings
+// and extends caller spans to cover their children.
+func finish(sp *Span) {
+	sortSpans(sp.Children)
+	var prevEnd time.Time
+	for _, c := range sp.Children {
+		finish(c)
+		if !prevEnd.IsZero() && c.Start.After(prevEnd) {
+			c.GapMS = c.Start.Sub(prevEnd).Milliseconds()
+		}
+		end := c.Start.Add(time.Duration(c.LatencyMS) * time.Millisecond)
+		if end.After(prevEnd) {
+			prevEnd = end
+		}
+		if sp.SessionID == "" {
+			if c.Start.Before(sp.Start) {
+				sp.Start = c.Start
+			}
+			sp.Tokens += c.Tokens
+		}
+	}
+	if sp.SessionID == "" && !prevEnd.IsZero() {
+		sp.LatencyMS = prevEnd.Sub(sp.Start).Milliseconds()
+	}
+}
+
+func sortSpans(spans []*Span) {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+}
+
+// Format renders the tree as indented text.
+func Format(t *Tree) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Trace %s (%d calls, %dms, %d tokens)\n", t.TraceID, t.Calls, t.DurationMS, t.Tokens)
+	for i, root := range t.Roots {
+		writeSpan(&b, root, "", i == len(t.Roots)-1)
+	}
+	return b.String()
+}
+
+func writeSpan(b *strings.Builder, sp *Span, prefix string, last bool) {
+	branch, next := "├─ ", "│  "
+	if last {
+		branch, next = "└─ ", "   "
+	}
+	if sp.GapMS > 0 {
+		fmt.Fprintf(b, "%s│  … %dms between calls\n", prefix, sp.GapMS)
+	}
+	if sp.SessionID == "" {
+		fmt.Fprintf(b, "%s%sspan %s (caller, %dms, %d tokens)\n", prefix, branch, sp.SpanID, sp.LatencyMS, sp.Tokens)
+	} else {
+		fmt.Fprintf(b, "%s%s%s %s %dms %d tokens [span %s]\n", prefix, branch, sp.SessionID, sp.Model, sp.LatencyMS, sp.Tokens, sp.SpanID)
+	}
+	for i, c := range sp.Children {
+		writeSpan(b, c, prefix+next, i == len(sp.Children)-1)
+	}
+}
@@ -0,0 +1,62 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promptkit/promptkit/pkg/session"
+)
+
+func TestParse(t *testing.T) {
+	c, ok := Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || c.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || c.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected context %+v %v", c, ok)
+	}
+	if c.String() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("unexpected header %s", c.String())
+	}
+	for _, bad := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, ok := Parse(bad); ok {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	base := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	call := func(id, span, parent string, end time.Duration, latency int64, tokens int) session.Session {
+		return session.Session{
+			ID:       id,
+			Request:  session.OpenAIRequest{Model: "gpt"},
+			Response: session.OpenAIResponse{Usage: session.UsageStats{TotalTokens: tokens}},
+			Metadata: session.Metadata{
+				Timestamp: base.Add(end), LatencyMS: latency,
+				TraceID: "t1", SpanID: span, ParentSpanID: parent,
+			},
+		}
+	}
+	sessions := []session.Session{
+		call("b", "s2", "agent", 3*time.Second, 500, 20),
+		call("a", "s1", "agent", time.Second, 1000, 10),
+		{ID: "other", Metadata: session.Metadata{TraceID: "t2"}},
+	}
+
+	tree := Build(sessions, "t1")
+	if tree == nil || tree.Calls != 2 || tree.Tokens != 30 || tree.DurationMS != 3000 {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+	if len(tree.Roots) != 1 || tree.Roots[0].SpanID != "agent" {
+		t.Fatalf("expected a single caller root, got %+v", tree.Roots)
+	}
+	kids := tree.Roots[0].Children
+	if len(kids) != 2 || kids[0].SessionID != "a" || kids[1].GapMS != 1500 {
+		t.Fatalf("unexpected children %+v %+v", kids[0], kids[1])
+	}
+	if !strings.Contains(Format(tree), "1500ms between calls") {
+		t.Fatalf("gap missing from output:\n%s", Format(tree))
+	}
+	if Build(sessions, "missing") != nil {
+		t.Fatalf("expected nil for unknown trace")
+	}
+}
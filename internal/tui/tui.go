@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
@@ -14,7 +15,9 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
+	"github.com/promptkit/promptkit/internal/diff"
 	"github.com/promptkit/promptkit/internal/list"
+	"github.com/promptkit/promptkit/internal/thread"
 	"github.com/promptkit/promptkit/pkg/session"
 )
 
@@ -35,22 +38,33 @@ func getPathValue(data any, path []string) (any, bool) {
 
 // Model represents the TUI state.
 type Model struct {
-	addr     string
+	base     string       // control API base URL
+	client   *http.Client // carries the API token and TLS settings
 	sessions []list.Summary
 	cursor   int
-	selected map[int]struct{}
+	selected map[string]struct{}
 	details  *session.Session
+	diff     *diff.Result
+	thread   *thread.Thread
 	width    int
 	height   int
-	events   chan session.Session
-	viewport viewport.Model
-	help     help.Model
-	keys     keyMap
-	showHelp bool
+	events   chan streamEvent
+	// lastEvent is the ID of the last event received, sent when the stream
+	// reconnects so missed events are replayed.
+	lastEvent string
+	viewport  viewport.Model
+	help      help.Model
+	keys      keyMap
+	showHelp  bool
 }
 
-func New(addr string) *Model {
-	m := &Model{addr: addr, selected: make(map[int]struct{})}
+// New returns a UI for the control API at base, e.g. http://localhost:5140,
+// reached with client. A nil client uses http.DefaultClient.
+func New(base string, client *http.Client) *Model {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	m := &Model{base: base, client: client, selected: make(map[string]struct{})}
 	m.help = help.New()
 	m.keys = newKeyMap()
 	return m
@@ -58,7 +72,7 @@ func New(addr string) *Model {
 
 func (m *Model) Init() tea.Cmd {
 	m.viewport = viewport.New(0, 0)
-	return tea.Batch(m.loadSessions(), subscribeCmd(m.addr))
+	return tea.Batch(m.loadSessions(), m.subscribeCmd(""))
 }
 
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -80,15 +94,41 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, m.loadDetail(m.sessions[m.cursor].ID)
 			}
 		case key.Matches(msg, m.keys.Space):
-			if _, ok := m.selected[m.cursor]; ok {
-				delete(m.selected, m.cursor)
-			} else {
-				m.selected[m.cursor] = struct{}{}
+			if m.cursor >= 0 && m.cursor < len(m.sessions) {
+				id := m.sessions[m.cursor].ID
+				if _, ok := m.selected[id]; ok {
+					delete(m.selected, id)
+				} else {
+					m.selected[id] = struct{}{}
+				}
+			}
+		case key.Matches(msg, m.keys.Diff):
+			if len(m.selected) == 2 {
+				var ids []string
+				for _, s := range m.sessions {
+					if _, ok := m.selected[s.ID]; ok {
+						ids = append(ids, s.ID)
+					}
+				}
+				if len(ids) == 2 {
+					return m, m.loadDiff(ids[0], ids[1])
+				}
+			}
+		case key.Matches(msg, m.keys.Thread):
+			if m.cursor >= 0 && m.cursor < len(m.sessions) {
+				return m, m.loadThread(m.sessions[m.cursor].ID)
+			}
+		case key.Matches(msg, m.keys.Back):
+			m.diff = nil
+			m.thread = nil
+			if m.details != nil {
+				m.viewport.SetContent(renderPrompt(*m.details))
+				m.viewport.GotoTop()
 			}
 		case key.Matches(msg, m.keys.Help):
 			m.showHelp = !m.showHelp
 		default:
-			if m.details != nil {
+			if m.details != nil || m.diff != nil || m.thread != nil {
 				var cmd tea.Cmd
 				m.viewport, cmd = m.viewport.Update(msg)
 				return m, cmd
@@ -102,18 +142,47 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.cursor >= len(m.sessions) {
 			m.cursor = len(m.sessions) - 1
 		}
+		listed := make(map[string]bool, len(m.sessions))
+		for _, s := range m.sessions {
+			listed[s.ID] = true
+		}
+		for id := range m.selected {
+			if !listed[id] {
+				delete(m.selected, id)
+			}
+		}
 	case sessionMsg:
 		m.sessions = append([]list.Summary{list.Summarize(msg.Session)}, m.sessions...)
 	case detailMsg:
 		m.details = &msg.Session
+		m.diff = nil
+		m.thread = nil
 		m.viewport.SetContent(renderPrompt(msg.Session))
 		m.viewport.GotoTop()
+	case diffMsg:
+		m.diff = &msg.Result
+		m.thread = nil
+		m.viewport.SetContent(diff.SideBySide(msg.Result, m.viewport.Width))
+		m.viewport.GotoTop()
+	case threadMsg:
+		m.thread = &msg.Thread
+		m.diff = nil
+		m.viewport.SetContent(thread.Format(&msg.Thread))
+		m.viewport.GotoTop()
 	case subscribeReadyMsg:
 		m.events = msg.ch
 		return m, waitEventCmd(m.events)
 	case eventMsg:
-		m.sessions = append([]list.Summary{list.Summarize(msg.Session)}, m.sessions...)
+		if msg.ID != "" {
+			m.lastEvent = msg.ID
+		}
+		m.applyEvent(msg.streamEvent)
 		return m, waitEventCmd(m.events)
+	case streamClosedMsg:
+		subscribe := m.subscribeCmd(m.lastEvent)
+		return m, tea.Tick(reconnectDelay, func(time.Time) tea.Msg {
+			return subscribe()
+		})
 	case errorMsg:
 		fmt.Println("error:", msg.err)
 	}
@@ -144,7 +213,7 @@ func (m *Model) renderList(width int) string {
 			cursor = ">"
 		}
 		mark := "[ ]"
-		if _, ok := m.selected[i]; ok {
+		if _, ok := m.selected[s.ID]; ok {
 			mark = "[x]"
 		}
 		b.WriteString(fmt.Sprintf("%s %s %s\n", cursor, mark, s.ID))
@@ -153,6 +222,12 @@ func (m *Model) renderList(width int) string {
 }
 
 func (m *Model) renderDetail(width int) string {
+	if m.diff != nil {
+		return m.renderDiff(width)
+	}
+	if m.thread != nil {
+		return m.renderThread(width)
+	}
 	if m.details == nil {
 		return lipgloss.NewStyle().Width(width).Render("no session selected")
 	}
@@ -163,11 +238,19 @@ func (m *Model) renderDetail(width int) string {
 		modelVal, _ = getPathValue(smap, []string{"request", "payload", "model"})
 	}
 
-	head := fmt.Sprintf("Origin: %s\nModel: %v\nLatency: %dms\nTags: %v", s.Origin, modelVal, s.Metadata.LatencyMS, s.Metadata.Tags)
+	cost := list.FormatCost(s.Metadata.CostUSD)
+	if u := s.Metadata.Usage; u != nil {
+		cost = fmt.Sprintf("%s (%d in / %d out tokens", cost, u.PromptTokens, u.CompletionTokens)
+		if u.Estimated {
+			cost += ", estimated"
+		}
+		cost += ")"
+	}
+	head := fmt.Sprintf("Origin: %s\nModel: %v\nLatency: %dms\nCost: %s\nTags: %v", s.Origin, modelVal, s.Metadata.LatencyMS, cost, s.Metadata.Tags)
 
 	helpHeight := lipgloss.Height(m.help.View(m.keys))
 	headerLines := 1
-	metaLines := 4 // origin + model + latency + tags
+	metaLines := 5 // origin + model + latency + cost + tags
 	vh := m.height - headerLines - metaLines - helpHeight
 	if vh < 1 {
 		vh = 1
@@ -178,6 +261,33 @@ func (m *Model) renderDetail(width int) string {
 	return lipgloss.NewStyle().Width(width).Render(head + "\n" + strings.Repeat("-", width) + "\n" + m.viewport.View())
 }
 
+func (m *Model) renderDiff(width int) string {
+	head := fmt.Sprintf("Diff: %s ↔ %s (%d changes)", m.diff.A, m.diff.B, len(m.diff.Entries))
+	helpHeight := lipgloss.Height(m.help.View(m.keys))
+	vh := m.height - 1 - 2 - helpHeight // header + diff heading and rule
+	if vh < 1 {
+		vh = 1
+	}
+	if m.viewport.Width != width {
+		m.viewport.SetContent(diff.SideBySide(*m.diff, width))
+	}
+	m.viewport.Width = width
+	m.viewport.Height = vh
+	return lipgloss.NewStyle().Width(width).Render(head + "\n" + strings.Repeat("-", width) + "\n" + m.viewport.View())
+}
+
+func (m *Model) renderThread(width int) string {
+	head := fmt.Sprintf("Thread: %s (%d turns)", m.thread.ID, len(m.thread.Turns))
+	helpHeight := lipgloss.Height(m.help.View(m.keys))
+	vh := m.height - 1 - 2 - helpHeight // header + thread heading and rule
+	if vh < 1 {
+		vh = 1
+	}
+	m.viewport.Width = width
+	m.viewport.Height = vh
+	return lipgloss.NewStyle().Width(width).Render(head + "\n" + strings.Repeat("-", width) + "\n" + m.viewport.View())
+}
+
 func renderPrompt(s session.Session) string {
 	var b strings.Builder
 	if sp := strings.TrimSpace(s.SourcePrompt); sp != "" {
@@ -191,31 +301,37 @@ func renderPrompt(s session.Session) string {
 
 // keyMap defines key bindings for the UI.
 type keyMap struct {
-	Up    key.Binding
-	Down  key.Binding
-	Enter key.Binding
-	Space key.Binding
-	Help  key.Binding
-	Quit  key.Binding
+	Up     key.Binding
+	Down   key.Binding
+	Enter  key.Binding
+	Space  key.Binding
+	Diff   key.Binding
+	Thread key.Binding
+	Back   key.Binding
+	Help   key.Binding
+	Quit   key.Binding
 }
 
 func newKeyMap() keyMap {
 	return keyMap{
-		Up:    key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
-		Down:  key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
-		Enter: key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "details")),
-		Space: key.NewBinding(key.WithKeys("space"), key.WithHelp("space", "select")),
-		Help:  key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "toggle help")),
-		Quit:  key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+		Up:     key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+		Down:   key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+		Enter:  key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "details")),
+		Space:  key.NewBinding(key.WithKeys("space", " "), key.WithHelp("space", "select")),
+		Diff:   key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "diff selected")),
+		Thread: key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "thread")),
+		Back:   key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+		Help:   key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "toggle help")),
+		Quit:   key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
 	}
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.Up, k.Down, k.Enter, k.Space, k.Help, k.Quit}
+	return []key.Binding{k.Up, k.Down, k.Enter, k.Space, k.Diff, k.Thread, k.Help, k.Quit}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
-	return [][]key.Binding{{k.Up, k.Down, k.Enter, k.Space, k.Help, k.Quit}}
+	return [][]key.Binding{{k.Up, k.Down, k.Enter, k.Space, k.Diff, k.Thread, k.Back, k.Help, k.Quit}}
 }
 
 // messages
@@ -226,17 +342,37 @@ type sessionMsg struct{ Session session.Session }
 
 type detailMsg struct{ Session session.Session }
 
-type subscribeReadyMsg struct{ ch chan session.Session }
+type diffMsg struct{ Result diff.Result }
+
+type threadMsg struct{ Thread thread.Thread }
 
-type eventMsg struct{ Session session.Session }
+type subscribeReadyMsg struct{ ch chan streamEvent }
+
+type eventMsg struct{ streamEvent }
+
+type streamClosedMsg struct{}
 
 type errorMsg struct{ err error }
 
 // commands
 
+// get fetches path from the control API. Responses other than 200 and 404
+// are returned as errors, e.g. when the API token is wrong.
+func (m *Model) get(path string) (*http.Response, error) {
+	resp, err := m.client.Get(m.base + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp, nil
+}
+
 func (m *Model) loadSessions() tea.Cmd {
 	return func() tea.Msg {
-		resp, err := http.Get("http://" + m.addr + "/sessions")
+		resp, err := m.get("/sessions")
 		if err != nil {
 			return errorMsg{err}
 		}
@@ -251,7 +387,7 @@ func (m *Model) loadSessions() tea.Cmd {
 
 func (m *Model) loadDetail(id string) tea.Cmd {
 	return func() tea.Msg {
-		resp, err := http.Get("http://" + m.addr + "/sessions/" + id)
+		resp, err := m.get("/sessions/" + id)
 		if err != nil {
 			return errorMsg{err}
 		}
@@ -264,17 +400,108 @@ func (m *Model) loadDetail(id string) tea.Cmd {
 	}
 }
 
-func subscribeCmd(addr string) tea.Cmd {
+func (m *Model) loadDiff(a, b string) tea.Cmd {
+	load := m.loadDetail
+	return func() tea.Msg {
+		var sessions [2]session.Session
+		for i, id := range []string{a, b} {
+			switch msg := load(id)().(type) {
+			case detailMsg:
+				sessions[i] = msg.Session
+			default:
+				return msg
+			}
+		}
+		return diffMsg{diff.Sessions(sessions[0], sessions[1])}
+	}
+}
+
+func (m *Model) loadThread(id string) tea.Cmd {
 	return func() tea.Msg {
-		url := "http://" + addr + "/events"
-		resp, err := http.Get(url)
+		resp, err := m.get("/threads/" + id)
 		if err != nil {
 			return errorMsg{err}
 		}
-		ch := make(chan session.Session)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return errorMsg{fmt.Errorf("session %s is not part of a chat thread", id)}
+		}
+		var th thread.Thread
+		if err := json.NewDecoder(resp.Body).Decode(&th); err != nil {
+			return errorMsg{err}
+		}
+		return threadMsg{th}
+	}
+}
+
+// reconnectDelay is how long the UI waits before reopening a closed event
+// stream.
+const reconnectDelay = 2 * time.Second
+
+// streamEvent is a server-sent event from the control API. Deletions only
+// carry the session ID.
+type streamEvent struct {
+	ID      string
+	Type    string
+	Session session.Session
+}
+
+// applyEvent updates the session list for a new, changed or deleted session.
+func (m *Model) applyEvent(ev streamEvent) {
+	idx := -1
+	for i, s := range m.sessions {
+		if s.ID == ev.Session.ID {
+			idx = i
+			break
+		}
+	}
+	switch ev.Type {
+	case "session.updated":
+		if idx >= 0 {
+			m.sessions[idx] = list.Summarize(ev.Session)
+		}
+	case "session.deleted":
+		delete(m.selected, ev.Session.ID)
+		if idx >= 0 {
+			m.sessions = append(m.sessions[:idx], m.sessions[idx+1:]...)
+			if m.cursor >= len(m.sessions) && m.cursor > 0 {
+				m.cursor--
+			}
+		}
+	default:
+		// A replayed event may announce a session that is already listed.
+		if idx >= 0 {
+			m.sessions[idx] = list.Summarize(ev.Session)
+		} else {
+			m.sessions = append([]list.Summary{list.Summarize(ev.Session)}, m.sessions...)
+		}
+	}
+}
+
+// subscribeCmd opens the event stream, resuming after lastEvent if set.
+func (m *Model) subscribeCmd(lastEvent string) tea.Cmd {
+	client, url := m.client, m.base+"/events"
+	return func() tea.Msg {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return errorMsg{err}
+		}
+		if lastEvent != "" {
+			req.Header.Set("Last-Event-ID", lastEvent)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return streamClosedMsg{}
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return errorMsg{fmt.Errorf("event stream: %s", resp.Status)}
+		}
+		ch := make(chan streamEvent)
 		go func() {
 			defer resp.Body.Close()
 			reader := bufio.NewReader(resp.Body)
+			var ev streamEvent
 			var data bytes.Buffer
 			for {
 				line, err := reader.ReadBytes('\n')
@@ -282,18 +509,27 @@ func subscribeCmd(addr string) tea.Cmd {
 					close(ch)
 					return
 				}
-				if bytes.Equal(line, []byte("\n")) {
+				field, value, _ := bytes.Cut(bytes.TrimRight(line, "\r\n"), []byte(":"))
+				value = bytes.TrimPrefix(value, []byte(" "))
+				switch string(field) {
+				case "":
+					// A blank line ends an event; a leading colon is a comment.
+					if len(line) > 0 && line[0] == ':' {
+						continue
+					}
 					if data.Len() > 0 {
-						var sess session.Session
-						if err := json.Unmarshal(data.Bytes(), &sess); err == nil {
-							ch <- sess
+						if err := json.Unmarshal(data.Bytes(), &ev.Session); err == nil {
+							ch <- ev
 						}
-						data.Reset()
 					}
-					continue
-				}
-				if bytes.HasPrefix(line, []byte("data:")) {
-					data.Write(bytes.TrimSpace(line[len("data:"):]))
+					ev = streamEvent{}
+					data.Reset()
+				case "id":
+					ev.ID = string(value)
+				case "event":
+					ev.Type = string(value)
+				case "data":
+					data.Write(value)
 				}
 			}
 		}()
@@ -301,12 +537,12 @@ func subscribeCmd(addr string) tea.Cmd {
 	}
 }
 
-func waitEventCmd(ch chan session.Session) tea.Cmd {
+func waitEventCmd(ch chan streamEvent) tea.Cmd {
 	return func() tea.Msg {
-		s, ok := <-ch
+		ev, ok := <-ch
 		if !ok {
-			return errorMsg{fmt.Errorf("event stream closed")}
+			return streamClosedMsg{}
 		}
-		return eventMsg{s}
+		return eventMsg{ev}
 	}
 }
@@ -1,42 +1,43 @@
 package view
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"log"
 	"os"
-	"path/filepath"
 
+	"github.com/promptkit/promptkit/internal/sessionfile"
 	"github.com/promptkit/promptkit/pkg/session"
 )
 
-// FindSession searches all .jsonl files under dir for a session with the given ID.
+// FindSession searches all session log files under dir for a session with the given ID.
 func FindSession(dir, id string) (*session.Session, error) {
-	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	files, err := sessionfile.Files(dir)
 	if err != nil {
 		return nil, err
 	}
+	dec := sessionfile.NewDecoder()
 	for _, f := range files {
-		file, err := os.Open(f)
-		if err != nil {
-			return nil, err
-		}
-		sc := bufio.NewScanner(file)
-		for sc.Scan() {
-			var s session.Session
-			if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+		var found *session.Session
+		err := dec.Scan(f, func(s session.Session, err error) bool {
+			if err != nil {
 				log.Printf("skip corrupt session in %s: %v", f, err)
-				continue
+				return true
 			}
 			if s.ID == id {
-				file.Close()
-				return &s, nil
+				found = &s
+				return false
 			}
+			return true
+		})
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return nil, err
+		} else if err != nil {
+			log.Print(err)
 		}
-		if err := sc.Err(); err != nil {
-			log.Printf("reading %s: %v", f, err)
+		if found != nil {
+			return found, nil
 		}
-		file.Close()
 	}
 	return nil, nil
 }
@@ -0,0 +1,246 @@
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+)
+
+// The accessors below read a session regardless of whether it was stored in
+// the structured format or the legacy proxy format (raw Payload and Body).
+
+// RequestPayload returns the request as a generic JSON object.
+func (s Session) RequestPayload() map[string]any {
+	if p, ok := s.Request.Payload.(map[string]any); ok {
+		return p
+	}
+	b, _ := json.Marshal(s.Request)
+	var m map[string]any
+	json.Unmarshal(b, &m)
+	for _, k := range []string{"method", "path", "payload"} {
+		delete(m, k)
+	}
+	return m
+}
+
+// Model returns the model named in the request.
+func (s Session) Model() string {
+	if s.Request.Model != "" {
+		return s.Request.Model
+	}
+	if m, ok := s.RequestPayload()["model"].(string); ok {
+		return m
+	}
+	return ""
+}
+
+// Messages returns the chat messages sent in the request.
+func (s Session) Messages() []Message {
+	if len(s.Request.Messages) > 0 {
+		return s.Request.Messages
+	}
+	raw, ok := s.RequestPayload()["messages"].([]any)
+	if !ok {
+		return nil
+	}
+	msgs := make([]Message, 0, len(raw))
+	for _, r := range raw {
+		m, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		role, _ := m["role"].(string)
+		name, _ := m["name"].(string)
+		msgs = append(msgs, Message{Role: role, Name: name, Content: contentText(m["content"])})
+	}
+	return msgs
+}
+
+// PromptText returns the prompt of a non-chat completion request.
+func (s Session) PromptText() string {
+	p := s.Request.Prompt
+	if p == nil {
+		p = s.RequestPayload()["prompt"]
+	}
+	return contentText(p)
+}
+
+// Params returns the request parameters other than the messages and prompt.
+func (s Session) Params() map[string]any {
+	params := map[string]any{}
+	for k, v := range s.RequestPayload() {
+		if k != "messages" && k != "prompt" {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// ResponseText returns the completion text of the first choice, assembling
+// streamed chunks when the response was recorded as server-sent events.
+func (s Session) ResponseText() string {
+	if len(s.Response.Choices) > 0 {
+		return s.Response.Choices[0].Message.Content
+	}
+	switch body := s.Response.Body.(type) {
+	case map[string]any:
+		return choiceText(body, "message")
+	case string:
+		var b strings.Builder
+		for _, chunk := range StreamChunks(body) {
+			b.WriteString(choiceText(chunk, "delta"))
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// StreamChunks decodes the JSON data events of a recorded SSE stream.
+func StreamChunks(body string) []map[string]any {
+	var chunks []map[string]any
+	sc := bufio.NewScanner(strings.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err == nil {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func choiceText(body map[string]any, field string) string {
+	choices, _ := body["choices"].([]any)
+	if len(choices) == 0 {
+		return ""
+	}
+	c, _ := choices[0].(map[string]any)
+	if msg, ok := c[field].(map[string]any); ok {
+		return contentText(msg["content"])
+	}
+	text, _ := c["text"].(string)
+	return text
+}
+
+// contentText flattens string, string-list and content-part values to text.
+func contentText(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []any:
+		var parts []string
+		for _, p := range t {
+			switch pt := p.(type) {
+			case string:
+				parts = append(parts, pt)
+			case map[string]any:
+				if text, ok := pt["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// Usage returns the token usage reported by the backend, including the usage
+// chunk of streamed responses that requested it.
+func (s Session) Usage() UsageStats {
+	if s.Response.Usage != (UsageStats{}) {
+		return s.Response.Usage
+	}
+	var raw any
+	switch body := s.Response.Body.(type) {
+	case map[string]any:
+		raw = body["usage"]
+	case string:
+		for _, chunk := range StreamChunks(body) {
+			if u, ok := chunk["usage"].(map[string]any); ok {
+				raw = u
+			}
+		}
+	}
+	u, _ := raw.(map[string]any)
+	num := func(k string) int {
+		f, _ := u[k].(float64)
+		return int(f)
+	}
+	return UsageStats{
+		PromptTokens:     num("prompt_tokens"),
+		CompletionTokens: num("completion_tokens"),
+		TotalTokens:      num("total_tokens"),
+	}
+}
+
+// CachedTokens returns the number of prompt tokens served from the backend's
+// prompt cache, if reported.
+func (s Session) CachedTokens() int {
+	var raw any
+	switch body := s.Response.Body.(type) {
+	case map[string]any:
+		raw = body["usage"]
+	case string:
+		for _, chunk := range StreamChunks(body) {
+			if u, ok := chunk["usage"]; ok && u != nil {
+				raw = u
+			}
+		}
+	}
+	u, _ := raw.(map[string]any)
+	details, _ := u["prompt_tokens_details"].(map[string]any)
+	n, _ := details["cached_tokens"].(float64)
+	return int(n)
+}
+
+// FinishReason returns the finish reason of the first choice.
+func (s Session) FinishReason() string {
+	if len(s.Response.Choices) > 0 {
+		return s.Response.Choices[0].FinishReason
+	}
+	var reason string
+	switch body := s.Response.Body.(type) {
+	case map[string]any:
+		reason = choiceField(body, "finish_reason")
+	case string:
+		for _, chunk := range StreamChunks(body) {
+			if r := choiceField(chunk, "finish_reason"); r != "" {
+				reason = r
+			}
+		}
+	}
+	return reason
+}
+
+// ResponseModel returns the model that served the response.
+func (s Session) ResponseModel() string {
+	if s.Response.Model != "" {
+		return s.Response.Model
+	}
+	switch body := s.Response.Body.(type) {
+	case map[string]any:
+		m, _ := body["model"].(string)
+		return m
+	case string:
+		for _, chunk := range StreamChunks(body) {
+			if m, ok := chunk["model"].(string); ok {
+				return m
+			}
+		}
+	}
+	return ""
+}
+
+func choiceField(body map[string]any, field string) string {
+	choices, _ := body["choices"].([]any)
+	if len(choices) == 0 {
+		return ""
+	}
+	c, _ := choices[0].(map[string]any)
+	v, _ := c[field].(string)
+	return v
+}
@@ -0,0 +1,47 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FromExchange builds a session in the proxy format from a raw HTTP exchange
+// that started at start. It fails if the request body is not a JSON object.
+func FromExchange(method, path string, reqBody []byte, status int, respBody []byte, start time.Time) (Session, error) {
+	var reqPayload map[string]any
+	if err := json.Unmarshal(reqBody, &reqPayload); err != nil {
+		return Session{}, err
+	}
+	var respPayload any
+	if err := json.Unmarshal(respBody, &respPayload); err != nil {
+		respPayload = string(respBody)
+	}
+
+	stream := false
+	if v, ok := reqPayload["stream"].(bool); ok && v {
+		stream = true
+	}
+
+	return Session{
+		ID:           NewID(time.Now()),
+		Origin:       OriginProxy,
+		SourcePrompt: "",
+		Request: OpenAIRequest{
+			// Legacy proxy format for backward compatibility
+			Method:  method,
+			Path:    path,
+			Payload: reqPayload,
+			Stream:  stream,
+		},
+		Response: OpenAIResponse{
+			// Legacy proxy format for backward compatibility
+			Status: status,
+			Body:   respPayload,
+		},
+		Stream: stream,
+		Metadata: Metadata{
+			Timestamp: time.Now(),
+			LatencyMS: time.Since(start).Milliseconds(),
+		},
+	}, nil
+}
@@ -1,6 +1,10 @@
 package session
 
-import "time"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
 
 type Origin string
 
@@ -9,6 +13,7 @@ const (
 	OriginFramework Origin = "framework"
 	OriginModelKit  Origin = "modelkit"
 	OriginProxy     Origin = "proxy" // For backward compatibility with existing sessions
+	OriginRerun     Origin = "rerun"
 )
 
 // Session represents one prompt-response transaction recorded by promptkit.
@@ -29,6 +34,80 @@ type Metadata struct {
 	Tags        []string  `json:"tags,omitempty"`
 	Published   *string   `json:"published,omitempty"` // OCI ref if published
 	SessionHash string    `json:"session_hash"`
+	ParentID    string    `json:"parent_id,omitempty"` // session this one was re-run from
+	ThreadID    string    `json:"thread_id,omitempty"` // usually the ID of the first session of the conversation
+	Turn        int       `json:"turn,omitempty"`      // 1-based position within the thread
+	// W3C trace context of the call, set when the client sent a traceparent.
+	TraceID      string `json:"trace_id,omitempty"`
+	SpanID       string `json:"span_id,omitempty"`
+	ParentSpanID string `json:"parent_span_id,omitempty"`
+	// Usage and CostUSD are computed by the daemon from the backend's usage
+	// report, or estimated locally when the backend did not report any.
+	Usage   *TokenUsage `json:"usage,omitempty"`
+	CostUSD float64     `json:"cost_usd,omitempty"`
+	// QueueWaitMS is the time the request waited for a rate limit slot. It
+	// is not included in LatencyMS.
+	QueueWaitMS int64 `json:"queue_wait_ms,omitempty"`
+	// Backend names the upstream that served the request.
+	Backend string `json:"backend,omitempty"`
+	// Attempts lists every upstream call when the daemon retried or fell
+	// back to another backend.
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// Cache is set when the daemon's response cache considered the request.
+	Cache *CacheInfo `json:"cache,omitempty"`
+	// Fault names the failure the daemon injected into the response.
+	Fault string `json:"fault,omitempty"`
+	// Status is empty for requests that were forwarded to the backend.
+	Status Status `json:"status,omitempty"`
+	// KeyHash is a fingerprint of the API key the request was made with.
+	KeyHash string `json:"key_hash,omitempty"`
+	// Client names the local client that made the call, when the daemon
+	// has a client key map.
+	Client string `json:"client,omitempty"`
+	// Notes is free text added after the session was recorded.
+	Notes string `json:"notes,omitempty"`
+}
+
+// Attempt is one upstream call of a request.
+type Attempt struct {
+	Backend   string `json:"backend,omitempty"`
+	Status    int    `json:"status,omitempty"` // 0 if no response was received
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	BackoffMS int64  `json:"backoff_ms,omitempty"` // wait before the next attempt
+}
+
+// CacheInfo records how the response cache handled a request.
+type CacheInfo struct {
+	Status   CacheStatus `json:"status"`
+	Key      string      `json:"key"`
+	SourceID string      `json:"source_id,omitempty"` // session a hit was served from
+}
+
+// CacheStatus is the outcome of a cache lookup.
+type CacheStatus string
+
+const (
+	CacheHit    CacheStatus = "hit"
+	CacheMiss   CacheStatus = "miss"
+	CacheBypass CacheStatus = "bypass" // the client asked for a fresh response
+)
+
+// Status records how the daemon handled a request.
+type Status string
+
+const (
+	// StatusRejected marks a request the daemon refused without forwarding
+	// it, e.g. because a budget was exceeded.
+	StatusRejected Status = "rejected"
+)
+
+// TokenUsage is the token accounting of a session.
+type TokenUsage struct {
+	PromptTokens     int  `json:"prompt_tokens"`
+	CompletionTokens int  `json:"completion_tokens"`
+	CachedTokens     int  `json:"cached_tokens,omitempty"`
+	Estimated        bool `json:"estimated,omitempty"` // counted locally, not by the backend
 }
 
 // OpenAIRequest captures a prompt sent to the OpenAI-compatible API.
@@ -80,3 +159,12 @@ type UsageStats struct {
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
 }
+
+// NewID returns a session ID for a session started at t: a timestamp with
+// one-second resolution followed by a random suffix, so sessions started in
+// the same second stay distinguishable.
+func NewID(t time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return t.Format("20060102150405") + "-" + hex.EncodeToString(suffix)
+}